@@ -1,9 +1,13 @@
 package client
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/murphybytes/ucp/common"
 	"github.com/murphybytes/ucp/wire"
@@ -21,48 +25,282 @@ func New(flags *common.Flags) common.Application {
 	}
 }
 
-// Run the client application
+var (
+	errInterrupted        = common.StatusError(wire.Canceled, "Interrupted, the partly copied file was discarded")
+	errCommandInterrupted = common.StatusError(wire.Canceled, "Interrupted")
+)
+
+// Run the client application, a copy or a subcommand managing remote files.
+// SIGINT or SIGTERM cancel it, a second signal exits at once
 func (c *Client) Run() (e error) {
-	var reader io.ReadCloser
-	var writer io.WriteCloser
+	if c.flags.Delta && !deltaPossible(c.flags) {
+		fmt.Fprintln(os.Stderr, "-delta can't be used with two local files, stdout or -relay, copying the whole file")
+		c.flags.Delta = false
+	}
+
+	// the shell cancels each command on its own
+	if c.flags.Command != nil && c.flags.Command[0] == "shell" {
+		return runShell(c.flags)
+	}
 
-	if reader, e = newReader(c.flags); e != nil {
-		log.Fatal(e.Error())
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// the default handling of a second signal exits
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	if c.flags.Command != nil {
+		if e = runCommand(ctx, c.flags); errors.Is(e, context.Canceled) {
+			e = errCommandInterrupted
+		}
+		return
 	}
-	defer reader.Close()
 
-	if writer, e = newWriter(c.flags); e != nil {
-		log.Fatal(e.Error())
+	if c.flags.Sync {
+		e = synchronize(ctx, c.flags)
+	} else {
+		e = copyFiles(ctx, c.flags)
 	}
-	defer writer.Close()
 
-	fmt.Println("opened reader and writer")
-	for {
-		var read int
-		readBuffer := make([]byte, wire.DataBufferSize)
+	if errors.Is(e, context.Canceled) {
+		e = errInterrupted
+	}
+
+	return
+}
+
+func copyFiles(ctx context.Context, flags *common.Flags) (e error) {
+	var from, to *fileInfo
+	if from, e = newFileInfo(flags.From, true); e != nil {
+		return
+	}
+
+	if to, e = newFileInfo(flags.To, false); e != nil {
+		return
+	}
+
+	return copyFile(ctx, flags, from, to)
+}
+
+// copyFile copies a single file from one location to another, either of
+// which may be local or remote.  Canceling ctx discards the partly written
+// destination.  Copies that fail because a connection failed are attempted
+// again flags.Retries times
+func copyFile(ctx context.Context, flags *common.Flags, from, to *fileInfo) error {
+	c := &fileCopy{flags: flags, from: from, to: to}
+	return c.run(ctx)
+}
+
+// fileCopy is a copy that can be attempted again after it failed.  A local
+// destination of a remote file is kept between attempts, the next attempt
+// reads the rest of the file
+type fileCopy struct {
+	flags    *common.Flags
+	from, to *fileInfo
+	// source and destination are the servers of from and to when a sync
+	// copies every file over the same sessions, otherwise nil
+	source, destination *syncServer
+	writer              *endpoint
+	// written is how much of the file the writer got, size how large the
+	// file was when the writer was opened
+	written  int64
+	size     int64
+	progress *progress
+}
+
+func (c *fileCopy) run(ctx context.Context) (e error) {
+	if !c.from.local && !c.to.local && !c.flags.Relay {
+		return retry(ctx, c.flags, c.from.path, func() error {
+			return c.push(ctx)
+		})
+	}
+
+	defer func() {
+		c.close(e)
+	}()
+
+	// what was read from stdin is gone
+	if c.from.stdio() {
+		return c.attempt(ctx)
+	}
+
+	return retry(ctx, c.flags, c.from.path, func() error {
+		return c.attempt(ctx)
+	})
+}
+
+// open starts an operation of type transfer on fi.  A file on a server of a
+// sync is reached over the server's session, any other file is opened on its
+// own
+func (c *fileCopy) open(ctx context.Context, server *syncServer, fi *fileInfo, transfer wire.TransferType, offset int64, destination *fileInfo) (ep *endpoint, e error) {
+	if server != nil {
+		var s *Session
+		if s, e = server.get(ctx, c.flags); e != nil {
+			return
+		}
+
+		return s.open(ctx, fi.path, transfer, offset, destination)
+	}
+
+	if ep, e = openEndpoint(ctx, fi, c.flags, transfer, destination, offset); e != nil {
+		if ep != nil {
+			ep.Close()
+		}
+		return nil, e
+	}
+
+	return
+}
+
+// push asks the server holding from to send it directly to the server holding
+// to.  The source server connects to the destination with the key pair of
+// from's user, so the destination has to accept that key for to's user
+func (c *fileCopy) push(ctx context.Context) (e error) {
+	var ep *endpoint
+	if ep, e = c.open(ctx, c.source, c.from, wire.ClientPushing, 0, c.to); e != nil {
+		return
+	}
+	defer func() {
+		release(ep, e)
+	}()
+
+	if e = ep.server.ConfirmKey(); e != nil {
+		return
+	}
+
+	// the server answers once the destination has stored the file, it reads
+	// nothing more until then
+	ep.server.Finished()
+	return ep.server.ReceiveResponse()
+}
+
+// resumable returns true if a failed attempt can be continued by the next
+func (c *fileCopy) resumable() bool {
+	return !c.from.local && c.to.local && !c.flags.Delta
+}
+
+func (c *fileCopy) attempt(ctx context.Context) (e error) {
+	defer func() {
+		if e != nil && !c.resumable() {
+			c.close(e)
+		}
+	}()
+
+	var reader *endpoint
+	if reader, e = c.open(ctx, c.source, c.from, wire.ClientReading, c.written, nil); e != nil {
+		return
+	}
+	defer func() {
+		release(reader, e)
+	}()
 
-		read, e = reader.Read(readBuffer)
+	if c.writer != nil && reader.size != c.size {
+		return errors.New(c.from.path + " changed while the copy was interrupted")
+	}
 
-		fmt.Printf("Read %d\n", read)
+	if c.writer == nil {
+		if c.writer, e = c.open(ctx, c.destination, c.to, wire.ClientWriting, 0, nil); e != nil {
+			return
+		}
 
-		if e == io.EOF {
-			break
+		// progress goes to stderr if the file is written to stdout
+		progressOut := os.Stdout
+		if c.to.stdio() {
+			progressOut = os.Stderr
 		}
 
-		if e != nil {
-			log.Fatal(e.Error())
+		c.size = reader.size
+		c.progress = newProgress(c.flags, c.from.path, reader.size, progressOut)
+	}
+
+	if c.flags.Delta {
+		if e = copyDelta(reader, c.writer, c.progress); e != nil {
+			return
 		}
+	} else {
+		readBuffer := make([]byte, wire.DataBufferSize)
+		for {
+			var read int
+			read, e = reader.Read(readBuffer)
+
+			if e == io.EOF {
+				break
+			}
+
+			if e != nil {
+				return
+			}
 
-		fmt.Println("Ready to writexxx ")
-		var written int
-		if written, e = writer.Write(readBuffer[:read]); e != nil {
-			fmt.Println("Got here")
-			log.Fatal(e.Error())
+			if _, e = c.writer.Write(readBuffer[:read]); e != nil {
+				return
+			}
+
+			c.written += int64(read)
+			c.progress.Write(readBuffer[:read])
 		}
+	}
 
-		fmt.Printf("client write successful wrote %d\n", written)
+	var md *wire.FileMetadata
+	if c.flags.Preserve {
+		if md, e = reader.metadata(); e != nil {
+			return
+		}
+	}
 
+	if e = c.writer.finish(md); e != nil {
+		return
 	}
 
+	c.progress.finish()
+
 	return
 }
+
+// close discards what was written unless the copy finished.  e is the outcome
+// of the copy
+func (c *fileCopy) close(e error) {
+	if c.writer != nil {
+		release(c.writer, e)
+		c.writer = nil
+	}
+	c.written = 0
+}
+
+// deltaPossible returns true if exactly one of the files is remote or the
+// source server sends the file directly.  Deltas are computed between a local
+// copy and a remote copy of a file
+func deltaPossible(flags *common.Flags) bool {
+	from, e := newFileInfo(flags.From, true)
+	if e != nil {
+		return false
+	}
+
+	to, e := newFileInfo(flags.To, false)
+	if e != nil {
+		return false
+	}
+
+	if !from.local && !to.local {
+		return !flags.Relay
+	}
+
+	// there is no existing copy of the file to compare against
+	if to.stdio() {
+		return false
+	}
+
+	return from.local != to.local
+}
+
+// copyDelta copies between a local and a remote file sending only the parts
+// that differ from the destination.  p counts the bytes of the file as they
+// are encoded or rebuilt
+func copyDelta(reader, writer *endpoint, p *progress) error {
+	if writer.server != nil {
+		return writer.server.WriteDelta(io.TeeReader(reader, p))
+	}
+
+	return reader.server.ReadDelta(writer.fileInfo.path, io.MultiWriter(writer, p))
+}
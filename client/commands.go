@@ -0,0 +1,159 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/murphybytes/ucp/common"
+)
+
+// runCommand runs the subcommand in flags.Command, connecting once to each
+// server its paths name
+func runCommand(ctx context.Context, flags *common.Flags) (e error) {
+	name := flags.Command[0]
+
+	var files []*fileInfo
+	for _, spec := range flags.Command[1:] {
+		var fi *fileInfo
+		if fi, e = newFileInfo(spec, true); e != nil {
+			return
+		}
+
+		if fi.local {
+			return errors.New("ucp " + name + " only works on remote paths such as user@host:/path, " + spec + " is local")
+		}
+		files = append(files, fi)
+	}
+
+	sessions := make(map[string]*Session)
+	defer func() {
+		for _, s := range sessions {
+			s.Close()
+		}
+	}()
+
+	session := func(fi *fileInfo) (s *Session, e error) {
+		server := fmt.Sprint(fi.user, "@", fi.host, ":", fi.port)
+		if s = sessions[server]; s == nil {
+			if s, e = dialFile(ctx, fi, flags); e != nil {
+				return
+			}
+			sessions[server] = s
+		}
+		return
+	}
+
+	if name == "mv" {
+		from, to := files[0], files[1]
+		if from.user != to.user || from.host != to.host || from.port != to.port {
+			return errors.New("ucp mv can't move files between servers, copy them and remove the originals instead")
+		}
+
+		var s *Session
+		if s, e = session(from); e != nil {
+			return
+		}
+
+		return s.Rename(ctx, from.path, to.path)
+	}
+
+	for i, fi := range files {
+		var s *Session
+		if s, e = session(fi); e != nil {
+			return
+		}
+
+		switch name {
+		case "ls":
+			var infos []FileInfo
+			if infos, e = s.List(ctx, fi.path); e != nil {
+				return
+			}
+
+			if len(files) > 1 {
+				if i > 0 {
+					fmt.Println()
+				}
+				fmt.Println(fi.spec() + ":")
+			}
+			e = printListing(os.Stdout, infos, flags.Long)
+		case "stat":
+			var info FileInfo
+			if info, e = s.Stat(ctx, fi.path); e != nil {
+				return
+			}
+			e = printStat(os.Stdout, fi.path, info)
+		case "mkdir":
+			e = s.Mkdir(ctx, fi.path, flags.Parents)
+		case "rm":
+			e = s.Remove(ctx, fi.path)
+		}
+
+		if e != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// dialFile opens a session with the server of the remote file fi
+func dialFile(ctx context.Context, fi *fileInfo, flags *common.Flags) (s *Session, e error) {
+	var logger common.Logger
+	if logger, e = common.NewLogger(flags); e != nil {
+		return
+	}
+
+	server := *fi
+	ep := &endpoint{
+		ctx:      ctx,
+		fileInfo: &server,
+		flags:    flags,
+		logger:   logger,
+		size:     -1,
+	}
+
+	if e = connect(ctx, ep); e != nil {
+		return
+	}
+
+	return &Session{endpoint: ep}, nil
+}
+
+// printListing writes the names of infos, one per line.  long adds the mode,
+// size and modification time of each
+func printListing(w io.Writer, infos []FileInfo, long bool) error {
+	if !long {
+		for _, info := range infos {
+			if _, e := fmt.Fprintln(w, info.Name); e != nil {
+				return e
+			}
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	for _, info := range infos {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t %s\n", info.Mode, info.Size, info.ModTime.Format("2006-01-02 15:04"), info.Name)
+	}
+
+	return tw.Flush()
+}
+
+// printStat writes the attributes of the file at path
+func printStat(w io.Writer, path string, info FileInfo) (e error) {
+	kind := "file"
+	if info.IsDir {
+		kind = "directory"
+	}
+
+	_, e = fmt.Fprintf(w, "Path: %s\nType: %s\nSize: %d\nMode: %s\nModified: %s\n",
+		path, kind, info.Size, info.Mode, info.ModTime.Format(time.RFC3339))
+
+	return
+}
@@ -0,0 +1,29 @@
+package client
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPrintListing(t *testing.T) {
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	infos := []FileInfo{
+		{Name: "file", Size: 12345, Mode: 0644, ModTime: modified},
+		{Name: "dir", Size: 4096, Mode: os.ModeDir | 0755, ModTime: modified, IsDir: true},
+	}
+
+	var out bytes.Buffer
+	if err := printListing(&out, infos, false); err != nil || out.String() != "file\ndir\n" {
+		t.Error("Unexpected listing ", out.String())
+	}
+
+	out.Reset()
+	printListing(&out, infos, true)
+	expected := "  -rw-r--r--  12345  2020-01-02 03:04 file\n" +
+		"  drwxr-xr-x   4096  2020-01-02 03:04 dir\n"
+	if out.String() != expected {
+		t.Error("Unexpected long listing\n", out.String())
+	}
+}
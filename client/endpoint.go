@@ -0,0 +1,280 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/murphybytes/ucp/common"
+	"github.com/murphybytes/ucp/remote"
+	"github.com/murphybytes/ucp/wire"
+)
+
+type endpoint struct {
+	// ctx cancels the operation in progress
+	ctx          context.Context
+	fileInfo     *fileInfo
+	flags        *common.Flags
+	logger       common.Logger
+	server       *remote.Conn
+	file         io.ReadWriteCloser
+	pending      *common.AtomicFile
+	transfer     wire.TransferType
+	options      wire.TransferOption
+	destination  *fileInfo
+	newPath      string
+	size         int64
+	offset       int64
+	stopWatching func()
+	// session performs operations over the endpoint one after another, nil if
+	// the endpoint is used for one operation
+	session *Session
+}
+
+// openEndpoint opens the file described by fi.  For a remote file it connects
+// to the server and starts an operation of type transfer.  destination is
+// only used by ClientPushing transfers, offset by ClientReading transfers of
+// remote files, which start there.  Canceling ctx stops the operation
+func openEndpoint(ctx context.Context, fi *fileInfo, flags *common.Flags, transfer wire.TransferType, destination *fileInfo, offset int64) (ep *endpoint, e error) {
+	var logger common.Logger
+	if logger, e = common.NewLogger(flags); e != nil {
+		return
+	}
+
+	ep = &endpoint{
+		ctx:         ctx,
+		fileInfo:    fi,
+		flags:       flags,
+		logger:      logger,
+		transfer:    transfer,
+		options:     common.TransferOptions(flags),
+		destination: destination,
+		offset:      offset,
+		size:        -1,
+	}
+
+	if !fi.local {
+		// remote endpoints read or write encrypted bytes to a socket
+		if e = connect(ctx, ep); e != nil {
+			return
+		}
+
+		ep.watch(ctx)
+		e = initTransfer(ep)
+
+	} else {
+		// local endpoints read or write to a file
+		if fi.stdio() {
+			if transfer == wire.ClientReading {
+				ep.file = stdio{os.Stdin}
+			} else {
+				ep.file = stdio{os.Stdout}
+			}
+		} else if transfer == wire.ClientReading {
+			var f *os.File
+			if f, e = os.Open(fi.path); e != nil {
+				return
+			}
+			ep.file = f
+
+			if info, err := f.Stat(); err == nil && info.Mode().IsRegular() {
+				ep.size = info.Size()
+			}
+		} else {
+			if common.TransferOptions(flags).Has(wire.CreateDirectories) {
+				if e = os.MkdirAll(filepath.Dir(fi.path), 0777); e != nil {
+					return
+				}
+			}
+
+			if ep.pending, e = common.NewAtomicFile(fi.path); e != nil {
+				return
+			}
+			ep.file = ep.pending
+
+		}
+	}
+
+	return
+}
+
+// release ends the operation of ep with the outcome e.  The endpoint of a
+// session stays connected for the session's next operation, others are closed
+func release(ep *endpoint, e error) {
+	if ep.session != nil {
+		ep.session.release(e)
+		return
+	}
+
+	ep.Close()
+}
+
+// connect dials the server of a remote endpoint and authenticates, giving up
+// once ctx is done
+func connect(ctx context.Context, ep *endpoint) (e error) {
+	var connectString string
+	connectString, e = ep.fileInfo.getConnectString()
+	ep.logger.LogInfo("Client connecting to ", connectString)
+	if e != nil {
+		return
+	}
+
+	ep.server, e = remote.Dial(ctx, connectString, ep.fileInfo.user, ep.flags.PrivateKeyPath, ep.flags.Limit)
+
+	return
+}
+
+// begin starts another operation of type transfer on path over the
+// connection of a remote endpoint whose last operation has finished
+func (c *endpoint) begin(ctx context.Context, path string, transfer wire.TransferType, options wire.TransferOption) error {
+	c.watch(ctx)
+	c.fileInfo.path = path
+	c.transfer = transfer
+	c.options = options
+	c.size = -1
+
+	return initTransfer(c)
+}
+
+func initTransfer(ep *endpoint) (e error) {
+	txfrRequest := wire.FileTransferRequest{
+		FilePath: ep.fileInfo.path,
+		Transfer: ep.transfer,
+		Options:  ep.options,
+		Offset:   ep.offset,
+		NewPath:  ep.newPath,
+		// the flag is validated so the name is known
+		Compression: common.CompressionMethods[ep.flags.Compress],
+	}
+
+	if ep.destination != nil {
+		txfrRequest.Destination = ep.destination.spec()
+	}
+
+	if e = ep.server.Begin(ep.ctx, txfrRequest); e != nil {
+		return
+	}
+
+	ep.size = ep.server.Size()
+
+	return
+}
+
+// metadata returns the attributes of the file this endpoint reads from.  For
+// remote files they are sent by the server after the last data packet
+func (c *endpoint) metadata() (md *wire.FileMetadata, e error) {
+	if c.server != nil {
+		if md = c.server.Metadata(); md == nil {
+			e = errors.New("Server did not send file metadata")
+		}
+		return
+	}
+
+	return common.GetFileMetadata(c.fileInfo.path, common.TransferOptions(c.flags))
+}
+
+// finish is called once all data has been written.  It applies md to the
+// destination if it is not nil and moves the destination into place, until
+// then Close discards everything written
+func (c *endpoint) finish(md *wire.FileMetadata) (e error) {
+	if c.server != nil {
+		return c.server.FinishWrite(md)
+	}
+
+	if e = c.ctx.Err(); e != nil {
+		return
+	}
+
+	if c.pending == nil {
+		return nil
+	}
+
+	if md != nil {
+		if e = common.ApplyFileMetadata(c.pending.Name(), md, common.TransferOptions(c.flags)); e != nil {
+			return
+		}
+	}
+
+	return c.pending.Commit()
+}
+
+// stdio reads stdin or writes stdout.  Closing it leaves the stream open
+type stdio struct {
+	*os.File
+}
+
+func (s stdio) Close() error {
+	return nil
+}
+
+func (c *endpoint) getIO() io.ReadWriteCloser {
+	if c.server != nil {
+		return c.server
+	}
+
+	return c.file
+}
+
+// watch makes ctx cancel the operations of the endpoint.  A remote endpoint
+// tells the server with the next message it sends, if that doesn't happen
+// within common.CancelGracePeriod the connection is closed
+func (c *endpoint) watch(ctx context.Context) {
+	c.unwatch()
+	c.ctx = ctx
+	if c.server != nil {
+		c.stopWatching = common.CloseOnCancel(ctx, c.server, common.CancelGracePeriod)
+	}
+}
+
+func (c *endpoint) unwatch() {
+	if c.stopWatching != nil {
+		c.stopWatching()
+		c.stopWatching = nil
+	}
+}
+
+// Read and Write fail once the operation is canceled.  Remote endpoints tell
+// the server when they send their next message
+func (c *endpoint) Read(p []byte) (n int, e error) {
+	if c.server == nil {
+		if e = c.ctx.Err(); e != nil {
+			return
+		}
+	}
+
+	reader := c.getIO()
+	return reader.Read(p)
+}
+
+func (c *endpoint) Write(p []byte) (n int, e error) {
+	if c.server == nil {
+		if e = c.ctx.Err(); e != nil {
+			return
+		}
+	}
+
+	writer := c.getIO()
+	return writer.Write(p)
+}
+
+// Close tells the server about an operation that was canceled while the
+// server waited for the endpoint's next message, then closes the connection or
+// file.  Files being written that were not finished are discarded
+func (c *endpoint) Close() error {
+	if c.server != nil {
+		c.unwatch()
+		if c.server.Transferring() && c.ctx.Err() != nil {
+			c.server.Cancel()
+		}
+	}
+
+	closer := c.getIO()
+	if closer != nil {
+		return closer.Close()
+	}
+
+	return nil
+
+}
@@ -4,12 +4,18 @@ import (
 	"errors"
 	"fmt"
 	"os/user"
-	"strconv"
+	"path"
+	"path/filepath"
 	"strings"
 
 	"github.com/murphybytes/ucp/common"
+	"github.com/murphybytes/ucp/remote"
 )
 
+// stdioPath is the local file spec for stdin when reading and stdout when
+// writing
+const stdioPath = "-"
+
 type fileInfo struct {
 	host  string
 	user  string
@@ -19,19 +25,6 @@ type fileInfo struct {
 	read  bool
 }
 
-func parseUserHost(userHost string) (user, host string, e error) {
-	parts := strings.Split(userHost, "@")
-	if len(parts) != 2 {
-		e = errors.New("Invalid host specification - " + userHost)
-		return
-	}
-
-	user = parts[0]
-	host = parts[1]
-	e = nil
-	return
-}
-
 // filespec takes the form
 // [user@host[:port]:]/path/to/file
 // is optional user@host is not supplied /path/to/file is assumed
@@ -56,21 +49,8 @@ func newFileInfo(filespec string, read bool) (fi *fileInfo, e error) {
 		fi.user = userInfo.Username
 	}
 
-	if len(parts) == 2 {
-		fi.path = parts[1]
-		if fi.user, fi.host, e = parseUserHost(parts[0]); e != nil {
-			return nil, e
-		}
-	}
-
-	if len(parts) == 3 {
-		fi.path = parts[2]
-		if fi.port, e = strconv.Atoi(parts[1]); e != nil {
-			e = errors.New("Invalid port format in file spec '" + filespec + "'")
-			return nil, e
-		}
-
-		if fi.user, fi.host, e = parseUserHost(parts[0]); e != nil {
+	if len(parts) > 1 {
+		if fi.user, fi.host, fi.port, fi.path, e = remote.ParseSpec(filespec); e != nil {
 			return nil, e
 		}
 	}
@@ -87,3 +67,25 @@ func (fi *fileInfo) getConnectString() (connectString string, e error) {
 	return
 
 }
+
+// stdio returns true if fi is stdin or stdout
+func (fi *fileInfo) stdio() bool {
+	return fi.local && fi.path == stdioPath
+}
+
+// spec returns the file spec of a remote file including the port
+func (fi *fileInfo) spec() string {
+	return fmt.Sprint(fi.user, "@", fi.host, ":", fi.port, ":", fi.path)
+}
+
+// child returns the file info of name, a slash separated path relative to the
+// directory fi describes
+func (fi *fileInfo) child(name string) *fileInfo {
+	child := *fi
+	if fi.local {
+		child.path = filepath.Join(fi.path, filepath.FromSlash(name))
+	} else {
+		child.path = path.Join(fi.path, name)
+	}
+	return &child
+}
@@ -101,3 +101,39 @@ func TestNewFileNoUserHost(t *testing.T) {
 	}
 
 }
+
+func TestStdio(t *testing.T) {
+	fi, e := newFileInfo("-", true)
+	if e != nil {
+		t.Fatal("Didn't expect error")
+	}
+
+	if !fi.local || !fi.stdio() {
+		t.Error("- should be stdin")
+	}
+
+	if fi, _ = newFileInfo("foo@bar:-", false); fi.stdio() {
+		t.Error("Remote file named - is not stdout")
+	}
+}
+
+func TestSpecRoundTrip(t *testing.T) {
+	fi, e := newFileInfo("foo@bar:/home/xxx", false)
+	if e != nil {
+		t.Fatal("Didn't expect error")
+	}
+
+	spec := fi.spec()
+	if spec != "foo@bar:9191:/home/xxx" {
+		t.Error("Unexpected spec ", spec)
+	}
+
+	parsed, e := newFileInfo(spec, false)
+	if e != nil {
+		t.Fatal("Didn't expect error parsing ", spec)
+	}
+
+	if *parsed != *fi {
+		t.Error("Spec did not round trip")
+	}
+}
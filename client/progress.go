@@ -0,0 +1,161 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/murphybytes/ucp/common"
+)
+
+const (
+	barInterval  = 200 * time.Millisecond
+	jsonInterval = time.Second
+	barWidth     = 30
+)
+
+// progress reports how far a copy has got.  Bytes are counted by writing them
+// to it.  A nil progress reports nothing
+type progress struct {
+	name       string
+	total      int64
+	done       int64
+	start      time.Time
+	lastReport time.Time
+	json       bool
+	out        io.Writer
+}
+
+// progressReport is the line written for -progress=json.  Total and
+// ETASeconds are -1 if the size of the file is unknown
+type progressReport struct {
+	File       string  `json:"file"`
+	Bytes      int64   `json:"bytes"`
+	Total      int64   `json:"total"`
+	Percent    float64 `json:"percent"`
+	Rate       float64 `json:"rate"`
+	ETASeconds float64 `json:"eta_seconds"`
+	Done       bool    `json:"done"`
+}
+
+func isTerminal(f *os.File) bool {
+	info, e := f.Stat()
+	return e == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// newProgress returns a progress for a copy of total bytes that reports to out,
+// total is -1 if it is not known
+func newProgress(flags *common.Flags, name string, total int64, out *os.File) *progress {
+	mode := flags.Progress
+	if mode == common.ProgressAuto {
+		mode = common.ProgressNone
+		if isTerminal(out) {
+			mode = common.ProgressBar
+		}
+	}
+
+	if mode != common.ProgressBar && mode != common.ProgressJSON {
+		return nil
+	}
+
+	return &progress{
+		name:  name,
+		total: total,
+		start: time.Now(),
+		json:  mode == common.ProgressJSON,
+		out:   out,
+	}
+}
+
+// Write counts the bytes in b as copied
+func (p *progress) Write(b []byte) (n int, e error) {
+	if p == nil {
+		return len(b), nil
+	}
+
+	p.done += int64(len(b))
+
+	interval := barInterval
+	if p.json {
+		interval = jsonInterval
+	}
+
+	if now := time.Now(); now.Sub(p.lastReport) >= interval {
+		p.lastReport = now
+		p.report(false)
+	}
+
+	return len(b), nil
+}
+
+// finish writes the final report
+func (p *progress) finish() {
+	if p != nil {
+		p.report(true)
+	}
+}
+
+func (p *progress) snapshot(done bool) (r progressReport) {
+	r = progressReport{
+		File:       p.name,
+		Bytes:      p.done,
+		Total:      p.total,
+		Percent:    -1,
+		ETASeconds: -1,
+		Done:       done,
+	}
+
+	if elapsed := time.Since(p.start).Seconds(); elapsed > 0 {
+		r.Rate = float64(p.done) / elapsed
+	}
+
+	if p.total > 0 {
+		r.Percent = float64(p.done) * 100 / float64(p.total)
+		if r.Rate > 0 {
+			r.ETASeconds = float64(p.total-p.done) / r.Rate
+		}
+	} else if p.total == 0 {
+		r.Percent = 100
+		r.ETASeconds = 0
+	}
+
+	return
+}
+
+func (p *progress) report(done bool) {
+	r := p.snapshot(done)
+
+	if p.json {
+		line, _ := json.Marshal(r)
+		fmt.Fprintf(p.out, "%s\n", line)
+		return
+	}
+
+	fmt.Fprintf(p.out, "\r%s", formatBar(r))
+	if done {
+		fmt.Fprintln(p.out)
+	}
+}
+
+func formatBar(r progressReport) string {
+	if r.Percent < 0 {
+		return fmt.Sprintf("%s  %s  %s/s", r.File, common.FormatBytes(float64(r.Bytes)), common.FormatBytes(r.Rate))
+	}
+
+	filled := int(r.Percent / 100 * barWidth)
+	if filled > barWidth {
+		filled = barWidth
+	}
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", barWidth-filled)
+
+	eta := "--:--"
+	if r.ETASeconds >= 0 {
+		seconds := int(r.ETASeconds + 0.5)
+		eta = fmt.Sprintf("%02d:%02d", seconds/60, seconds%60)
+	}
+
+	return fmt.Sprintf("%s %3.0f%% [%s] %s  %s/s  ETA %s", r.File, r.Percent, bar, common.FormatBytes(float64(r.Bytes)), common.FormatBytes(r.Rate), eta)
+}
@@ -0,0 +1,71 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatBar(t *testing.T) {
+	r := progressReport{
+		File:       "file",
+		Bytes:      512 * 1024,
+		Total:      1024 * 1024,
+		Percent:    50,
+		Rate:       1024 * 1024,
+		ETASeconds: 75,
+	}
+
+	bar := formatBar(r)
+	expected := "file  50% [===============               ] 512.0 KB  1.0 MB/s  ETA 01:15"
+	if bar != expected {
+		t.Error("Expected '", expected, "' got '", bar, "'")
+	}
+
+	r.Percent = -1
+	if bar = formatBar(r); strings.Contains(bar, "ETA") {
+		t.Error("Unknown size should not show ETA ", bar)
+	}
+}
+
+func TestJSONProgress(t *testing.T) {
+	var out bytes.Buffer
+	p := &progress{
+		name:  "file",
+		total: 200,
+		start: time.Now().Add(-time.Second),
+		json:  true,
+		out:   &out,
+	}
+
+	p.Write(make([]byte, 100))
+	p.finish()
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatal("Expected a periodic and a final line, got ", len(lines))
+	}
+
+	var r progressReport
+	if err := json.Unmarshal([]byte(lines[1]), &r); err != nil {
+		t.Fatal("Invalid JSON - ", err.Error())
+	}
+
+	if r.Bytes != 100 || r.Total != 200 || r.Percent != 50 || !r.Done {
+		t.Error("Unexpected report ", lines[1])
+	}
+
+	if r.ETASeconds <= 0 || r.Rate <= 0 {
+		t.Error("Expected rate and ETA to be estimated ", lines[1])
+	}
+}
+
+func TestNilProgress(t *testing.T) {
+	var p *progress
+	if n, err := p.Write(make([]byte, 10)); n != 10 || err != nil {
+		t.Error("nil progress should accept writes")
+	}
+	p.finish()
+}
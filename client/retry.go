@@ -0,0 +1,48 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/murphybytes/ucp/common"
+	"github.com/murphybytes/ucp/remote"
+)
+
+const (
+	// retryDelay is how long the first retry of a failed copy waits, each
+	// further retry waits twice as long up to maxRetryDelay
+	retryDelay    = time.Second
+	maxRetryDelay = 30 * time.Second
+)
+
+// retry calls attempt until it succeeds or fails with an error other than a
+// remote.ConnectionError, at most flags.Retries times more than once.  The
+// wait before each retry doubles
+func retry(ctx context.Context, flags *common.Flags, name string, attempt func() error) (e error) {
+	var logger common.Logger
+	if logger, e = common.NewLogger(flags); e != nil {
+		return
+	}
+
+	delay := retryDelay
+	for retries := 0; ; retries++ {
+		var failed remote.ConnectionError
+		if e = attempt(); e == nil || !errors.As(e, &failed) || ctx.Err() != nil || retries == flags.Retries {
+			return
+		}
+
+		logger.LogWarn(fmt.Sprintf("Copying %s failed - %s, reconnecting in %s (retry %d of %d)", name, e.Error(), delay, retries+1, flags.Retries))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/murphybytes/ucp/common"
+	"github.com/murphybytes/ucp/remote"
+)
+
+func TestRetry(t *testing.T) {
+	flags := &common.Flags{LogLevel: "ERROR", Retries: 1}
+
+	attempts := 0
+	e := retry(context.Background(), flags, "file", func() error {
+		attempts++
+		if attempts == 1 {
+			return remote.ConnectionError{Err: io.ErrUnexpectedEOF}
+		}
+		return nil
+	})
+
+	if e != nil || attempts != 2 {
+		t.Error("Expected the second attempt to succeed got ", e, " after ", attempts, " attempts")
+	}
+
+	attempts = 0
+	failed := errors.New("open file: permission denied")
+	e = retry(context.Background(), flags, "file", func() error {
+		attempts++
+		return failed
+	})
+
+	if e != failed || attempts != 1 {
+		t.Error("Expected errors other than connection failures not to be retried, got ", attempts, " attempts")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	attempts = 0
+	e = retry(ctx, flags, "file", func() error {
+		attempts++
+		return remote.ConnectionError{Err: io.ErrUnexpectedEOF}
+	})
+
+	if !errors.Is(e, io.ErrUnexpectedEOF) || attempts != 1 {
+		t.Error("Expected a cancelled copy not to be retried, got ", attempts, " attempts")
+	}
+}
@@ -0,0 +1,369 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/user"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/murphybytes/ucp/common"
+	"github.com/murphybytes/ucp/remote"
+	"github.com/murphybytes/ucp/wire"
+)
+
+var errSessionClosed = errors.New("Session is closed")
+
+// Options configure a Session
+type Options struct {
+	// User to log in as, the current user if empty
+	User string
+	// PrivateKeyPath is the key to authenticate with, ~/.ucp/ucp.pem if empty
+	PrivateKeyPath string
+	// Compress is none, gzip or auto, none if empty
+	Compress string
+	// Limit is the maximum transfer rate in bytes per second, 0 for no limit
+	Limit int64
+	// Logger receives the session's log lines, nothing is logged if nil
+	Logger common.Logger
+}
+
+// FileInfo describes a file or directory on the server
+type FileInfo struct {
+	Name    string
+	Size    int64
+	Mode    os.FileMode
+	ModTime time.Time
+	IsDir   bool
+}
+
+// Session is an authenticated connection to a ucp server that performs one
+// operation at a time.  Errors the server reports leave the session usable,
+// any other failure during an operation closes it, including canceling the
+// operation's context
+type Session struct {
+	mutex    sync.Mutex
+	endpoint *endpoint
+	// err is set once the session can't be used anymore
+	err error
+}
+
+// Dial connects to the ucp server at addr, host or host:port, and
+// authenticates.  ctx limits how long connecting and authenticating may take
+func Dial(ctx context.Context, addr string, opts Options) (s *Session, e error) {
+	var ep *endpoint
+	if ep, e = newSessionEndpoint(addr, opts); e != nil {
+		return
+	}
+
+	if e = connect(ctx, ep); e != nil {
+		return
+	}
+
+	return &Session{endpoint: ep}, nil
+}
+
+// newSessionEndpoint returns the remote endpoint a session at addr performs
+// its operations through
+func newSessionEndpoint(addr string, opts Options) (ep *endpoint, e error) {
+	fi := &fileInfo{
+		host: addr,
+		port: common.DefaultPort,
+		user: opts.User,
+	}
+
+	if host, port, err := net.SplitHostPort(addr); err == nil {
+		fi.host = host
+		if fi.port, e = strconv.Atoi(port); e != nil {
+			return nil, errors.New("Invalid port in address " + addr)
+		}
+	}
+
+	if fi.user == "" {
+		var u *user.User
+		if u, e = user.Current(); e != nil {
+			return
+		}
+		fi.user = u.Username
+	}
+
+	flags := &common.Flags{
+		PrivateKeyPath: opts.PrivateKeyPath,
+		Compress:       opts.Compress,
+		Limit:          common.Rate(opts.Limit),
+		Progress:       common.ProgressNone,
+	}
+
+	if flags.PrivateKeyPath == "" {
+		flags.PrivateKeyPath = common.DefaultPrivateKeyPath()
+	}
+
+	if flags.Compress == "" {
+		flags.Compress = common.CompressNone
+	}
+
+	if _, ok := common.CompressionMethods[flags.Compress]; !ok {
+		return nil, errors.New("Invalid compression " + flags.Compress)
+	}
+
+	logger := opts.Logger
+	if logger == nil {
+		logger = common.DiscardLogger()
+	}
+
+	ep = &endpoint{
+		fileInfo: fi,
+		flags:    flags,
+		logger:   logger,
+		size:     -1,
+		ctx:      context.Background(),
+	}
+
+	return
+}
+
+// begin starts an operation canceled by ctx, failing if the session can't be
+// used
+func (s *Session) begin(ctx context.Context, remotePath string, transfer wire.TransferType, options wire.TransferOption) error {
+	if s.err != nil {
+		return s.err
+	}
+
+	// an operation that started is ended by its caller
+	if e := s.endpoint.begin(ctx, remotePath, transfer, options); e != nil {
+		return s.end(e)
+	}
+
+	return nil
+}
+
+// end returns the outcome e of an operation.  Unless the server reported e
+// and waits for no more messages of the operation the connection is out of
+// step with the server and is closed
+func (s *Session) end(e error) error {
+	s.endpoint.unwatch()
+
+	var reported remote.ServerError
+	if e != nil && (!errors.As(e, &reported) || s.endpoint.server.Transferring()) {
+		s.endpoint.Close()
+		s.err = fmt.Errorf("Session closed after an earlier error - %s", e.Error())
+	}
+
+	return e
+}
+
+// open starts an operation of type transfer on remotePath with the options of
+// the session's flags and returns the endpoint performing it.  offset and
+// destination are used as by openEndpoint.  No other operation starts until
+// release ends this one
+func (s *Session) open(ctx context.Context, remotePath string, transfer wire.TransferType, offset int64, destination *fileInfo) (ep *endpoint, e error) {
+	s.mutex.Lock()
+
+	s.endpoint.session = s
+	s.endpoint.offset, s.endpoint.destination = offset, destination
+	e = s.begin(ctx, remotePath, transfer, common.TransferOptions(s.endpoint.flags))
+	// the next operation doesn't inherit them
+	s.endpoint.offset, s.endpoint.destination = 0, nil
+
+	if e != nil {
+		s.mutex.Unlock()
+		return nil, e
+	}
+
+	return s.endpoint, nil
+}
+
+// release ends the operation open started with its outcome e
+func (s *Session) release(e error) {
+	s.end(e)
+	s.mutex.Unlock()
+}
+
+// usable returns true if the session can perform another operation
+func (s *Session) usable() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.err == nil
+}
+
+// Upload stores the data read from r in the file remotePath.  The file is only
+// replaced once all of r has been sent
+func (s *Session) Upload(ctx context.Context, r io.Reader, remotePath string) (e error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if e = s.begin(ctx, remotePath, wire.ClientWriting, 0); e != nil {
+		return
+	}
+
+	buffer := make([]byte, wire.DataBufferSize)
+	for {
+		read, err := r.Read(buffer)
+		if read > 0 {
+			if _, e = s.endpoint.Write(buffer[:read]); e != nil {
+				return s.end(e)
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return s.end(err)
+		}
+	}
+
+	return s.end(s.endpoint.finish(nil))
+}
+
+// Download writes the contents of the file remotePath to w
+func (s *Session) Download(ctx context.Context, remotePath string, w io.Writer) (e error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if e = s.begin(ctx, remotePath, wire.ClientReading, 0); e != nil {
+		return
+	}
+
+	buffer := make([]byte, wire.DataBufferSize)
+	for {
+		var read int
+		if read, e = s.endpoint.Read(buffer); e == io.EOF {
+			return s.end(nil)
+		}
+
+		if e != nil {
+			return s.end(e)
+		}
+
+		if _, e = w.Write(buffer[:read]); e != nil {
+			return s.end(e)
+		}
+	}
+}
+
+// Stat describes the file or directory remotePath
+func (s *Session) Stat(ctx context.Context, remotePath string) (info FileInfo, e error) {
+	var entries []wire.FileEntry
+	if entries, e = s.list(ctx, remotePath, wire.ClientStating, 0); e != nil {
+		return
+	}
+
+	if len(entries) != 1 {
+		return info, errors.New("Server sent an invalid stat reply")
+	}
+
+	return entryInfo(entries[0]), nil
+}
+
+// List describes the files and directories directly inside the directory
+// remotePath.  If remotePath is a file the list only describes it
+func (s *Session) List(ctx context.Context, remotePath string) (infos []FileInfo, e error) {
+	var entries []wire.FileEntry
+	if entries, e = s.list(ctx, remotePath, wire.ClientListing, wire.ListShallow); e != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		infos = append(infos, entryInfo(entry))
+	}
+
+	return
+}
+
+// Mkdir creates the directory remotePath.  With parents missing parent
+// directories are created and an existing directory is not an error
+func (s *Session) Mkdir(ctx context.Context, remotePath string, parents bool) error {
+	var options wire.TransferOption
+	if parents {
+		options = wire.CreateDirectories
+	}
+
+	return s.change(ctx, remotePath, wire.ClientMakingDirectory, options, "")
+}
+
+// Remove removes the file or empty directory remotePath
+func (s *Session) Remove(ctx context.Context, remotePath string) error {
+	return s.change(ctx, remotePath, wire.ClientRemoving, 0, "")
+}
+
+// Rename moves the file or directory oldPath to newPath
+func (s *Session) Rename(ctx context.Context, oldPath, newPath string) error {
+	return s.change(ctx, oldPath, wire.ClientMoving, 0, newPath)
+}
+
+// change performs an operation that is answered by a single reply
+func (s *Session) change(ctx context.Context, remotePath string, transfer wire.TransferType, options wire.TransferOption, newPath string) (e error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.endpoint.newPath = newPath
+	e = s.begin(ctx, remotePath, transfer, options)
+	// the next operation doesn't inherit it
+	s.endpoint.newPath = ""
+
+	if e != nil {
+		return
+	}
+
+	if e = s.endpoint.server.ConfirmKey(); e != nil {
+		return s.end(e)
+	}
+
+	s.endpoint.server.Finished()
+	return s.end(s.endpoint.server.ReceiveResponse())
+}
+
+func (s *Session) list(ctx context.Context, remotePath string, transfer wire.TransferType, options wire.TransferOption) (entries []wire.FileEntry, e error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if e = s.begin(ctx, remotePath, transfer, options); e != nil {
+		return
+	}
+
+	entries, e = s.endpoint.server.ReadListing()
+
+	return entries, s.end(e)
+}
+
+// Close ends the session
+func (s *Session) Close() (e error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.err == errSessionClosed {
+		return
+	}
+
+	if s.err == nil {
+		e = s.endpoint.Close()
+	}
+	s.err = errSessionClosed
+
+	return
+}
+
+func entryInfo(entry wire.FileEntry) FileInfo {
+	info := FileInfo{
+		Name:    path.Base(entry.Name),
+		Size:    entry.Size,
+		Mode:    os.FileMode(entry.Mode),
+		ModTime: time.Unix(0, entry.ModTime),
+		IsDir:   entry.IsDir,
+	}
+
+	if info.IsDir {
+		info.Mode |= os.ModeDir
+	}
+
+	return info
+}
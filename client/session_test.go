@@ -0,0 +1,56 @@
+package client
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/murphybytes/ucp/common"
+	"github.com/murphybytes/ucp/wire"
+)
+
+func TestNewSessionEndpoint(t *testing.T) {
+	ep, e := newSessionEndpoint("foo.com", Options{User: "john"})
+	if e != nil {
+		t.Fatal("Unexpected error ", e.Error())
+	}
+
+	if ep.fileInfo.host != "foo.com" || ep.fileInfo.port != common.DefaultPort || ep.fileInfo.user != "john" {
+		t.Error("Unexpected endpoint ", ep.fileInfo.host, ep.fileInfo.port, ep.fileInfo.user)
+	}
+
+	if ep.flags.Compress != common.CompressNone || ep.flags.PrivateKeyPath == "" {
+		t.Error("Expected default compression and key, got ", ep.flags.Compress, ep.flags.PrivateKeyPath)
+	}
+
+	if ep, e = newSessionEndpoint("foo.com:1234", Options{User: "john"}); e != nil || ep.fileInfo.host != "foo.com" || ep.fileInfo.port != 1234 {
+		t.Error("Expected foo.com port 1234")
+	}
+
+	if _, e = newSessionEndpoint("foo.com:port", Options{User: "john"}); e == nil {
+		t.Error("Expected an invalid port to fail")
+	}
+
+	if _, e = newSessionEndpoint("foo.com", Options{User: "john", Compress: "zip"}); e == nil {
+		t.Error("Expected an invalid compression to fail")
+	}
+}
+
+func TestEntryInfo(t *testing.T) {
+	modified := time.Now()
+	info := entryInfo(wire.FileEntry{
+		Name:    "dir/sub",
+		Size:    4096,
+		ModTime: modified.UnixNano(),
+		Mode:    0755,
+		IsDir:   true,
+	})
+
+	if info.Name != "sub" || info.Size != 4096 || !info.ModTime.Equal(modified) {
+		t.Error("Unexpected info ", info)
+	}
+
+	if info.Mode != os.ModeDir|0755 || !info.Mode.IsDir() {
+		t.Error("Expected a directory mode, got ", info.Mode)
+	}
+}
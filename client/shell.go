@@ -0,0 +1,481 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/murphybytes/ucp/common"
+)
+
+// shell runs the commands of ucp shell over one session with a server
+type shell struct {
+	flags  *common.Flags
+	server *fileInfo
+	// session is replaced once a failure closes it
+	session *Session
+	// dir is the remote working directory, the home directory of the user if
+	// empty.  Relative directories are relative to the home directory
+	dir string
+	out io.Writer
+}
+
+type shellCommand struct {
+	usage string
+	help  string
+	run   func(sh *shell, ctx context.Context, args []string) error
+}
+
+var shellCommands map[string]shellCommand
+
+func init() {
+	// help lists shellCommands so the map can't be initialized with it
+	shellCommands = map[string]shellCommand{
+		"cd":    {"cd [dir]", "Change the remote directory, to the home directory without dir", (*shell).cd},
+		"get":   {"get remote [local]", "Download a file", (*shell).get},
+		"help":  {"help", "List the commands", (*shell).help},
+		"lcd":   {"lcd [dir]", "Change the local directory, to the home directory without dir", (*shell).lcd},
+		"lpwd":  {"lpwd", "Print the local directory", (*shell).lpwd},
+		"ls":    {"ls [-l] [path]", "List a remote directory", (*shell).ls},
+		"mget":  {"mget pattern...", "Download the files matching the patterns", (*shell).mget},
+		"mkdir": {"mkdir [-p] dir", "Create a remote directory", (*shell).mkdir},
+		"mput":  {"mput pattern...", "Upload the local files matching the patterns", (*shell).mput},
+		"mv":    {"mv path newpath", "Move a remote file or directory", (*shell).mv},
+		"put":   {"put local [remote]", "Upload a file", (*shell).put},
+		"pwd":   {"pwd", "Print the remote directory", (*shell).pwd},
+		"rm":    {"rm path", "Remove a remote file or empty directory", (*shell).rm},
+		"stat":  {"stat path", "Describe a remote file or directory", (*shell).stat},
+	}
+}
+
+var errShellUsage = errors.New("usage")
+
+// runShell reads commands from stdin until it ends or exit is entered.  SIGINT
+// cancels the command that is running
+func runShell(flags *common.Flags) (e error) {
+	sh := &shell{
+		flags: flags,
+		out:   os.Stdout,
+	}
+
+	// user@host[:port] followed by a colon is the spec of the home directory
+	if sh.server, e = newFileInfo(flags.Command[1]+":", true); e != nil {
+		return
+	}
+
+	if e = sh.connect(context.Background()); e != nil {
+		return
+	}
+	defer func() {
+		sh.session.Close()
+	}()
+
+	input := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Fprint(sh.out, "ucp:", sh.display(sh.dir), "> ")
+		if !input.Scan() {
+			fmt.Fprintln(sh.out)
+			return input.Err()
+		}
+
+		var args []string
+		if args, e = splitArgs(input.Text()); e != nil {
+			fmt.Fprintln(os.Stderr, e.Error())
+			continue
+		}
+
+		if len(args) == 0 {
+			continue
+		}
+
+		if args[0] == "exit" || args[0] == "quit" {
+			return nil
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		e = sh.run(ctx, args)
+		if errors.Is(e, context.Canceled) {
+			e = errCommandInterrupted
+		}
+		stop()
+
+		if e != nil {
+			fmt.Fprintln(os.Stderr, e.Error())
+		}
+	}
+}
+
+// run runs the command args, reconnecting first if a failure closed the
+// session
+func (sh *shell) run(ctx context.Context, args []string) (e error) {
+	command, ok := shellCommands[args[0]]
+	if !ok {
+		return errors.New("Unknown command " + args[0] + ", help lists the commands")
+	}
+
+	if sh.session.err != nil {
+		fmt.Fprintln(sh.out, "Reconnecting")
+		if e = sh.connect(ctx); e != nil {
+			return
+		}
+	}
+
+	if e = command.run(sh, ctx, args[1:]); e == errShellUsage {
+		e = errors.New("usage: " + command.usage)
+	}
+
+	return
+}
+
+func (sh *shell) connect(ctx context.Context) (e error) {
+	var s *Session
+	if s, e = dialFile(ctx, sh.server, sh.flags); e != nil {
+		return
+	}
+
+	sh.session = s
+	return
+}
+
+// resolve returns the remote path p names in the working directory
+func (sh *shell) resolve(p string) string {
+	if path.IsAbs(p) || sh.dir == "" {
+		return path.Clean(p)
+	}
+
+	return path.Join(sh.dir, p)
+}
+
+// display returns the remote path p as it is shown to the user, with ~ for
+// the home directory
+func (sh *shell) display(p string) string {
+	if path.IsAbs(p) {
+		return p
+	}
+
+	if p == "" || p == "." {
+		return "~"
+	}
+
+	return "~/" + p
+}
+
+func (sh *shell) cd(ctx context.Context, args []string) (e error) {
+	if len(args) > 1 {
+		return errShellUsage
+	}
+
+	if len(args) == 0 {
+		sh.dir = ""
+		return
+	}
+
+	dir := sh.resolve(args[0])
+
+	var info FileInfo
+	if info, e = sh.session.Stat(ctx, dir); e != nil {
+		return
+	}
+
+	if !info.IsDir {
+		return errors.New(sh.display(dir) + " is not a directory")
+	}
+
+	sh.dir = dir
+	if dir == "." {
+		sh.dir = ""
+	}
+
+	return
+}
+
+func (sh *shell) pwd(ctx context.Context, args []string) (e error) {
+	_, e = fmt.Fprintln(sh.out, sh.display(sh.dir))
+	return
+}
+
+func (sh *shell) ls(ctx context.Context, args []string) (e error) {
+	long := len(args) > 0 && args[0] == "-l"
+	if long {
+		args = args[1:]
+	}
+
+	if len(args) > 1 {
+		return errShellUsage
+	}
+
+	dir := sh.dir
+	if len(args) == 1 {
+		dir = sh.resolve(args[0])
+	}
+
+	if dir == "" {
+		dir = "."
+	}
+
+	var infos []FileInfo
+	if infos, e = sh.session.List(ctx, dir); e != nil {
+		return
+	}
+
+	return printListing(sh.out, infos, long)
+}
+
+func (sh *shell) stat(ctx context.Context, args []string) (e error) {
+	if len(args) != 1 {
+		return errShellUsage
+	}
+
+	p := sh.resolve(args[0])
+
+	var info FileInfo
+	if info, e = sh.session.Stat(ctx, p); e != nil {
+		return
+	}
+
+	return printStat(sh.out, sh.display(p), info)
+}
+
+func (sh *shell) mkdir(ctx context.Context, args []string) error {
+	parents := len(args) > 0 && args[0] == "-p"
+	if parents {
+		args = args[1:]
+	}
+
+	if len(args) != 1 {
+		return errShellUsage
+	}
+
+	return sh.session.Mkdir(ctx, sh.resolve(args[0]), parents)
+}
+
+func (sh *shell) rm(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errShellUsage
+	}
+
+	return sh.session.Remove(ctx, sh.resolve(args[0]))
+}
+
+func (sh *shell) mv(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return errShellUsage
+	}
+
+	return sh.session.Rename(ctx, sh.resolve(args[0]), sh.resolve(args[1]))
+}
+
+func (sh *shell) get(ctx context.Context, args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return errShellUsage
+	}
+
+	remote := sh.resolve(args[0])
+	local := path.Base(remote)
+	if len(args) == 2 {
+		local = args[1]
+		if info, err := os.Stat(local); err == nil && info.IsDir() {
+			local = filepath.Join(local, path.Base(remote))
+		}
+	}
+
+	return sh.download(ctx, remote, local)
+}
+
+// download copies the remote file to local, which is only replaced once the
+// whole file was received
+func (sh *shell) download(ctx context.Context, remote, local string) (e error) {
+	var file *common.AtomicFile
+	if file, e = common.NewAtomicFile(local); e != nil {
+		return
+	}
+	defer file.Close()
+
+	if e = sh.session.Download(ctx, remote, file); e != nil {
+		return
+	}
+
+	return file.Commit()
+}
+
+func (sh *shell) mget(ctx context.Context, args []string) (e error) {
+	if len(args) == 0 {
+		return errShellUsage
+	}
+
+	for _, pattern := range args {
+		pattern = sh.resolve(pattern)
+		dir := path.Dir(pattern)
+
+		var infos []FileInfo
+		if infos, e = sh.session.List(ctx, dir); e != nil {
+			return
+		}
+
+		matched := false
+		for _, info := range infos {
+			if ok, _ := path.Match(path.Base(pattern), info.Name); !ok || info.IsDir {
+				continue
+			}
+			matched = true
+
+			remote := path.Join(dir, info.Name)
+			fmt.Fprintln(sh.out, "Downloading", sh.display(remote))
+			if e = sh.download(ctx, remote, info.Name); e != nil {
+				return
+			}
+		}
+
+		if !matched {
+			return errors.New("No remote files match " + sh.display(pattern))
+		}
+	}
+
+	return
+}
+
+func (sh *shell) put(ctx context.Context, args []string) (e error) {
+	if len(args) == 0 || len(args) > 2 {
+		return errShellUsage
+	}
+
+	remote := sh.resolve(filepath.Base(args[0]))
+	if len(args) == 2 {
+		remote = sh.resolve(args[1])
+		if info, err := sh.session.Stat(ctx, remote); err == nil && info.IsDir {
+			remote = path.Join(remote, filepath.Base(args[0]))
+		}
+	}
+
+	return sh.upload(ctx, args[0], remote)
+}
+
+func (sh *shell) upload(ctx context.Context, local, remote string) (e error) {
+	var file *os.File
+	if file, e = os.Open(local); e != nil {
+		return
+	}
+	defer file.Close()
+
+	return sh.session.Upload(ctx, file, remote)
+}
+
+func (sh *shell) mput(ctx context.Context, args []string) (e error) {
+	if len(args) == 0 {
+		return errShellUsage
+	}
+
+	for _, pattern := range args {
+		var matches []string
+		if matches, e = filepath.Glob(pattern); e != nil {
+			return
+		}
+
+		uploaded := false
+		for _, local := range matches {
+			if info, err := os.Stat(local); err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			uploaded = true
+
+			remote := sh.resolve(filepath.Base(local))
+			fmt.Fprintln(sh.out, "Uploading", local)
+			if e = sh.upload(ctx, local, remote); e != nil {
+				return
+			}
+		}
+
+		if !uploaded {
+			return errors.New("No local files match " + pattern)
+		}
+	}
+
+	return
+}
+
+func (sh *shell) lcd(ctx context.Context, args []string) (e error) {
+	if len(args) > 1 {
+		return errShellUsage
+	}
+
+	dir := ""
+	if len(args) == 1 {
+		dir = args[0]
+	} else if dir, e = os.UserHomeDir(); e != nil {
+		return
+	}
+
+	return os.Chdir(dir)
+}
+
+func (sh *shell) lpwd(ctx context.Context, args []string) (e error) {
+	var dir string
+	if dir, e = os.Getwd(); e != nil {
+		return
+	}
+
+	_, e = fmt.Fprintln(sh.out, dir)
+	return
+}
+
+func (sh *shell) help(ctx context.Context, args []string) error {
+	var names []string
+	for name := range shellCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(sh.out, "%-20s %s\n", shellCommands[name].usage, shellCommands[name].help)
+	}
+	fmt.Fprintf(sh.out, "%-20s %s\n", "exit", "Leave the shell")
+
+	return nil
+}
+
+// splitArgs splits a command line at spaces.  Arguments with spaces can be
+// put in single or double quotes
+func splitArgs(line string) (args []string, e error) {
+	var arg strings.Builder
+	var quote rune
+	inArg := false
+
+	for _, r := range line {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			arg.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, errors.New("Missing closing quote")
+	}
+
+	if inArg {
+		args = append(args, arg.String())
+	}
+
+	return
+}
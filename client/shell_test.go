@@ -0,0 +1,38 @@
+package client
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	args, err := splitArgs(`put  "my file.txt" 'it''s' last `)
+	if err != nil || strings.Join(args, "|") != "put|my file.txt|its|last" {
+		t.Error("Unexpected arguments ", args, " ", err)
+	}
+
+	if args, err = splitArgs(`get ""`); err != nil || len(args) != 2 || args[1] != "" {
+		t.Error("Expected an empty quoted argument, got ", args)
+	}
+
+	if _, err = splitArgs(`get "file`); err == nil {
+		t.Error("Expected a missing quote to fail")
+	}
+}
+
+func TestShellPaths(t *testing.T) {
+	sh := &shell{}
+	if sh.resolve("data/../file") != "file" || sh.display(sh.dir) != "~" {
+		t.Error("Expected paths relative to the home directory, got ", sh.resolve("data/../file"))
+	}
+
+	sh.dir = "data"
+	if sh.resolve("file") != "data/file" || sh.display(sh.resolve("file")) != "~/data/file" {
+		t.Error("Expected paths relative to the working directory, got ", sh.resolve("file"))
+	}
+
+	sh.dir = "/srv"
+	if sh.resolve("../etc") != "/etc" || sh.resolve("/tmp/x/") != "/tmp/x" {
+		t.Error("Unexpected paths ", sh.resolve("../etc"), " ", sh.resolve("/tmp/x/"))
+	}
+}
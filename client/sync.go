@@ -0,0 +1,332 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/murphybytes/ucp/common"
+	"github.com/murphybytes/ucp/storage"
+	"github.com/murphybytes/ucp/wire"
+)
+
+const conflictMessage = "(type differs, use -delete to replace)"
+
+// syncPlan lists the work needed to make a destination directory look like a
+// source directory.  Names are slash separated and relative to the
+// directories being synchronized
+type syncPlan struct {
+	copies    []string
+	deletes   []string
+	conflicts []string
+}
+
+// newSyncPlan compares the listings of the source and destination.  A file is
+// copied if it is missing from the destination or differs in size, checksum
+// when checksums is true, or otherwise modification time.  Destination entries
+// missing from the source, or of a different type, are deleted when
+// deleteExtraneous is true.  Empty source directories are not created
+func newSyncPlan(source, destination []wire.FileEntry, checksums, deleteExtraneous bool) (plan *syncPlan) {
+	plan = &syncPlan{}
+
+	sourceByName := make(map[string]wire.FileEntry)
+	for _, entry := range source {
+		sourceByName[entry.Name] = entry
+	}
+
+	destinationByName := make(map[string]wire.FileEntry)
+	for _, entry := range destination {
+		destinationByName[entry.Name] = entry
+
+		sourceEntry, ok := sourceByName[entry.Name]
+		if ok && sourceEntry.IsDir == entry.IsDir {
+			continue
+		}
+
+		if deleteExtraneous {
+			plan.deletes = append(plan.deletes, entry.Name)
+		} else if ok {
+			plan.conflicts = append(plan.conflicts, entry.Name)
+		}
+	}
+
+	for _, entry := range source {
+		if entry.IsDir {
+			continue
+		}
+
+		existing, ok := destinationByName[entry.Name]
+		if !ok || (existing.IsDir && deleteExtraneous) || (!existing.IsDir && differs(entry, existing, checksums)) {
+			plan.copies = append(plan.copies, entry.Name)
+		}
+	}
+
+	// children sort after their parents so reverse order deletes them first
+	sort.Sort(sort.Reverse(sort.StringSlice(plan.deletes)))
+
+	return
+}
+
+func differs(source, destination wire.FileEntry, checksums bool) bool {
+	if source.Size != destination.Size {
+		return true
+	}
+
+	if checksums {
+		return !bytes.Equal(source.Checksum, destination.Checksum)
+	}
+
+	// file systems store times with different precision
+	return source.ModTime/int64(time.Second) != destination.ModTime/int64(time.Second)
+}
+
+// restat replaces the modification times of files that have the same size on
+// both sides but differ in time with the times stat returns.  Listings of
+// storage such as S3 report when files were stored rather than the times
+// preserved with them
+func restat(source, destination []wire.FileEntry, statSource, statDestination func(name string) (wire.FileEntry, error)) (e error) {
+	destinationByName := make(map[string]int)
+	for i, entry := range destination {
+		destinationByName[entry.Name] = i
+	}
+
+	for i, entry := range source {
+		j, ok := destinationByName[entry.Name]
+		if !ok || entry.IsDir || destination[j].IsDir || entry.Size != destination[j].Size || !differs(entry, destination[j], false) {
+			continue
+		}
+
+		var stated wire.FileEntry
+		if stated, e = statSource(entry.Name); e != nil {
+			return
+		}
+		source[i].ModTime = stated.ModTime
+
+		if stated, e = statDestination(entry.Name); e != nil {
+			return
+		}
+		destination[j].ModTime = stated.ModTime
+	}
+
+	return
+}
+
+func (p *syncPlan) print() {
+	for _, name := range p.conflicts {
+		fmt.Println("skip", name, conflictMessage)
+	}
+
+	for _, name := range p.deletes {
+		fmt.Println("delete", name)
+	}
+
+	for _, name := range p.copies {
+		fmt.Println("copy", name)
+	}
+}
+
+// syncServer is the server of a directory being synchronized.  One session
+// with it performs every operation on the directory, a new one is dialed
+// after a connection to the server failed
+type syncServer struct {
+	fi      *fileInfo
+	session *Session
+}
+
+// newSyncServer returns the server of the directory fi, nil if it is local
+func newSyncServer(fi *fileInfo) *syncServer {
+	if fi.local {
+		return nil
+	}
+
+	return &syncServer{fi: fi}
+}
+
+// get returns a session with the server, connecting if there is no usable one
+func (s *syncServer) get(ctx context.Context, flags *common.Flags) (session *Session, e error) {
+	if s.session != nil && s.session.usable() {
+		return s.session, nil
+	}
+
+	if session, e = dialFile(ctx, s.fi, flags); e != nil {
+		return
+	}
+	s.session = session
+
+	return
+}
+
+func (s *syncServer) Close() {
+	if s != nil && s.session != nil {
+		s.session.Close()
+	}
+}
+
+// list returns a recursive listing of the directory fi describes
+func list(ctx context.Context, fi *fileInfo, server *syncServer, flags *common.Flags) (entries []wire.FileEntry, e error) {
+	if fi.local {
+		// storage.Local resolves relative paths against the home directory
+		var root string
+		if root, e = filepath.Abs(fi.path); e != nil {
+			return
+		}
+
+		return storage.ListTree(storage.Local{}, fi.user, root, flags.Checksum)
+	}
+
+	var s *Session
+	if s, e = server.get(ctx, flags); e != nil {
+		return
+	}
+
+	if entries, e = s.list(ctx, fi.path, wire.ClientListing, common.TransferOptions(flags)); e != nil {
+		return
+	}
+
+	return entries, checkNames(entries)
+}
+
+// checkNames returns an error if the name of an entry of a listing is not a
+// path inside the directory that was listed.  Names are joined to local paths
+// so a server must not be able to name files elsewhere
+func checkNames(entries []wire.FileEntry) error {
+	for _, entry := range entries {
+		name := filepath.FromSlash(entry.Name)
+		valid := name != "" && !path.IsAbs(entry.Name) && !filepath.IsAbs(name) && filepath.VolumeName(name) == "" &&
+			filepath.Clean(name) == name
+		for _, element := range strings.Split(name, string(filepath.Separator)) {
+			valid = valid && element != ".."
+		}
+
+		if !valid {
+			return errors.New("Server sent an invalid name in a listing " + strconv.Quote(entry.Name))
+		}
+	}
+
+	return nil
+}
+
+// stat returns the entry of the file fi describes
+func stat(ctx context.Context, fi *fileInfo, server *syncServer, flags *common.Flags) (entry wire.FileEntry, e error) {
+	if fi.local {
+		var fullPath string
+		if fullPath, e = filepath.Abs(fi.path); e != nil {
+			return
+		}
+
+		return storage.StatEntry(storage.Local{}, fi.user, fullPath, false)
+	}
+
+	var s *Session
+	if s, e = server.get(ctx, flags); e != nil {
+		return
+	}
+
+	var entries []wire.FileEntry
+	if entries, e = s.list(ctx, fi.path, wire.ClientStating, 0); e != nil {
+		return
+	}
+
+	if len(entries) != 1 {
+		return entry, errors.New("Server sent an invalid stat reply")
+	}
+
+	return entries[0], nil
+}
+
+// remove deletes the file or empty directory fi describes
+func remove(ctx context.Context, fi *fileInfo, server *syncServer, flags *common.Flags) (e error) {
+	if fi.local {
+		return os.Remove(fi.path)
+	}
+
+	var s *Session
+	if s, e = server.get(ctx, flags); e != nil {
+		return
+	}
+
+	return s.Remove(ctx, fi.path)
+}
+
+// synchronize makes the directory flags.To look like the directory flags.From
+func synchronize(ctx context.Context, flags *common.Flags) (e error) {
+	var from, to *fileInfo
+	if from, e = newFileInfo(flags.From, true); e != nil {
+		return
+	}
+
+	if to, e = newFileInfo(flags.To, false); e != nil {
+		return
+	}
+
+	// every operation on a remote directory goes over one session
+	source, destination := newSyncServer(from), newSyncServer(to)
+	defer source.Close()
+	defer destination.Close()
+
+	var sourceEntries, destinationEntries []wire.FileEntry
+	if sourceEntries, e = list(ctx, from, source, flags); e != nil {
+		return
+	}
+
+	if destinationEntries, e = list(ctx, to, destination, flags); e != nil {
+		return
+	}
+
+	if !flags.Checksum {
+		statSource := func(name string) (wire.FileEntry, error) {
+			return stat(ctx, from.child(name), source, flags)
+		}
+		statDestination := func(name string) (wire.FileEntry, error) {
+			return stat(ctx, to.child(name), destination, flags)
+		}
+
+		if e = restat(sourceEntries, destinationEntries, statSource, statDestination); e != nil {
+			return
+		}
+	}
+
+	plan := newSyncPlan(sourceEntries, destinationEntries, flags.Checksum, flags.Delete)
+	if flags.DryRun {
+		plan.print()
+		return
+	}
+
+	for _, name := range plan.conflicts {
+		fmt.Println("skip", name, conflictMessage)
+	}
+
+	for _, name := range plan.deletes {
+		if e = ctx.Err(); e != nil {
+			return
+		}
+
+		if e = remove(ctx, to.child(name), destination, flags); e != nil {
+			return fmt.Errorf("delete %s: %w", name, e)
+		}
+	}
+
+	for _, name := range plan.copies {
+		c := &fileCopy{
+			flags:       flags,
+			from:        from.child(name),
+			to:          to.child(name),
+			source:      source,
+			destination: destination,
+		}
+
+		if e = c.run(ctx); e != nil {
+			return fmt.Errorf("copy %s: %w", filepath.FromSlash(name), e)
+		}
+	}
+
+	return
+}
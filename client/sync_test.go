@@ -0,0 +1,241 @@
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/murphybytes/ucp/common"
+	"github.com/murphybytes/ucp/storage"
+	"github.com/murphybytes/ucp/wire"
+)
+
+func TestSyncPlan(t *testing.T) {
+	now := time.Now().UnixNano()
+	earlier := now - int64(time.Hour)
+
+	source := []wire.FileEntry{
+		{Name: "dir", IsDir: true},
+		{Name: "dir/same", Size: 10, ModTime: now},
+		{Name: "dir/newer", Size: 10, ModTime: now},
+		{Name: "dir/resized", Size: 11, ModTime: now},
+		{Name: "missing", Size: 1, ModTime: now},
+		{Name: "wasdir", Size: 1, ModTime: now},
+	}
+
+	destination := []wire.FileEntry{
+		{Name: "dir", IsDir: true},
+		{Name: "dir/same", Size: 10, ModTime: now},
+		{Name: "dir/newer", Size: 10, ModTime: earlier},
+		{Name: "dir/resized", Size: 10, ModTime: now},
+		{Name: "extra", IsDir: true},
+		{Name: "extra/file", Size: 1, ModTime: now},
+		{Name: "wasdir", IsDir: true},
+	}
+
+	plan := newSyncPlan(source, destination, false, false)
+	expectedCopies := []string{"dir/newer", "dir/resized", "missing"}
+	if !reflect.DeepEqual(plan.copies, expectedCopies) {
+		t.Error("Expected copies ", expectedCopies, " got ", plan.copies)
+	}
+
+	if len(plan.deletes) != 0 {
+		t.Error("Nothing should be deleted without -delete, got ", plan.deletes)
+	}
+
+	if !reflect.DeepEqual(plan.conflicts, []string{"wasdir"}) {
+		t.Error("Expected wasdir conflict, got ", plan.conflicts)
+	}
+
+	plan = newSyncPlan(source, destination, false, true)
+	expectedCopies = append(expectedCopies, "wasdir")
+	if !reflect.DeepEqual(plan.copies, expectedCopies) {
+		t.Error("Expected copies ", expectedCopies, " got ", plan.copies)
+	}
+
+	expectedDeletes := []string{"wasdir", "extra/file", "extra"}
+	if !reflect.DeepEqual(plan.deletes, expectedDeletes) {
+		t.Error("Expected deletes ", expectedDeletes, " got ", plan.deletes)
+	}
+}
+
+func TestSyncPlanChecksums(t *testing.T) {
+	now := time.Now().UnixNano()
+	source := []wire.FileEntry{{Name: "a", Size: 1, ModTime: now, Checksum: []byte{1}}}
+	destination := []wire.FileEntry{{Name: "a", Size: 1, ModTime: now, Checksum: []byte{2}}}
+
+	if plan := newSyncPlan(source, destination, true, false); len(plan.copies) != 1 {
+		t.Error("Expected file with different checksum to be copied")
+	}
+
+	destination[0].Checksum = []byte{1}
+	destination[0].ModTime = 0
+	if plan := newSyncPlan(source, destination, true, false); len(plan.copies) != 0 {
+		t.Error("Expected file with same checksum to be skipped")
+	}
+}
+
+func TestCheckNames(t *testing.T) {
+	valid := []wire.FileEntry{{Name: "dir", IsDir: true}, {Name: "dir/file"}, {Name: "..hidden"}}
+	if err := checkNames(valid); err != nil {
+		t.Error("Expected names inside the directory to be accepted, got ", err)
+	}
+
+	for _, name := range []string{"../outside", "dir/../../outside", "/etc/passwd", "dir/./file", "dir//file", "dir/", ""} {
+		hostile := append(valid, wire.FileEntry{Name: name})
+		if err := checkNames(hostile); err == nil {
+			t.Error("Expected a listing naming " + strconv.Quote(name) + " to be rejected")
+		}
+	}
+}
+
+// newS3Server serves objects from memory with the requests storage.S3 needs to
+// store, stat and list small files
+func newS3Server() *httptest.Server {
+	var mutex sync.Mutex
+	objects := make(map[string][]byte)
+	headers := make(map[string]http.Header)
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+		switch {
+		case r.Method == "PUT":
+			objects[key], _ = ioutil.ReadAll(r.Body)
+			headers[key] = http.Header{"Last-Modified": {time.Now().UTC().Format(http.TimeFormat)}}
+			for name, values := range r.Header {
+				if strings.HasPrefix(name, "X-Amz-Meta-") {
+					headers[key][name] = values
+				}
+			}
+		case r.Method == "HEAD":
+			if _, ok := objects[key]; !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			for name, values := range headers[key] {
+				w.Header()[name] = values
+			}
+			w.Header().Set("Content-Length", fmt.Sprint(len(objects[key])))
+		case r.Method == "GET" && key == "":
+			prefix := r.URL.Query().Get("prefix")
+
+			var keys []string
+			for key := range objects {
+				if strings.HasPrefix(key, prefix) {
+					keys = append(keys, key)
+				}
+			}
+			sort.Strings(keys)
+
+			fmt.Fprint(w, "<ListBucketResult>")
+			prefixes := make(map[string]bool)
+			for _, key := range keys {
+				if i := strings.Index(key[len(prefix):], "/"); i >= 0 {
+					if !prefixes[key[:len(prefix)+i+1]] {
+						prefixes[key[:len(prefix)+i+1]] = true
+						fmt.Fprintf(w, "<CommonPrefixes><Prefix>%s</Prefix></CommonPrefixes>", key[:len(prefix)+i+1])
+					}
+					continue
+				}
+
+				modified, _ := http.ParseTime(headers[key].Get("Last-Modified"))
+				fmt.Fprintf(w, "<Contents><Key>%s</Key><Size>%d</Size><LastModified>%s</LastModified></Contents>",
+					key, len(objects[key]), modified.Format(time.RFC3339))
+			}
+			fmt.Fprint(w, "</ListBucketResult>")
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+}
+
+func TestSyncS3(t *testing.T) {
+	server := newS3Server()
+	defer server.Close()
+
+	s3 := &storage.S3{Endpoint: server.URL, Bucket: "bucket", Region: "us-east-1", AccessKey: "access", SecretKey: "secret"}
+
+	dir, err := ioutil.TempDir("", "ucp")
+	if err != nil {
+		t.Fatal("Temp dir creation failed -", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	usr, err := user.Current()
+	if err != nil {
+		t.Fatal("Current user lookup failed -", err.Error())
+	}
+
+	modTime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	for _, name := range []string{"a", "sub/b"} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err = ioutil.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal("Write failed -", err.Error())
+		}
+		os.Chtimes(path, modTime, modTime)
+	}
+
+	run := func() *syncPlan {
+		source, err := storage.ListTree(storage.Local{}, usr.Username, dir, false)
+		if err != nil {
+			t.Fatal("Listing the source failed -", err.Error())
+		}
+
+		destination, err := storage.ListTree(s3, "alice", "/backup", false)
+		if err != nil {
+			t.Fatal("Listing the destination failed -", err.Error())
+		}
+
+		statSource := func(name string) (wire.FileEntry, error) {
+			return storage.StatEntry(storage.Local{}, usr.Username, filepath.Join(dir, filepath.FromSlash(name)), false)
+		}
+		statDestination := func(name string) (wire.FileEntry, error) {
+			return storage.StatEntry(s3, "alice", "/backup/"+name, false)
+		}
+
+		if err = restat(source, destination, statSource, statDestination); err != nil {
+			t.Fatal("restat failed -", err.Error())
+		}
+
+		plan := newSyncPlan(source, destination, false, false)
+
+		// copy with -p as the server would
+		for _, name := range plan.copies {
+			path := filepath.Join(dir, filepath.FromSlash(name))
+			md, _ := common.GetFileMetadata(path, wire.PreserveMetadata)
+			data, _ := ioutil.ReadFile(path)
+
+			pending, _ := s3.Create("alice", "/backup/"+name, true)
+			pending.Write(data)
+			if err = pending.Commit(md, wire.PreserveMetadata); err != nil {
+				t.Fatal("Commit failed -", err.Error())
+			}
+		}
+
+		return plan
+	}
+
+	if plan := run(); !reflect.DeepEqual(plan.copies, []string{"a", "sub/b"}) {
+		t.Fatal("Expected the first sync to copy every file, got ", plan.copies)
+	}
+
+	if plan := run(); len(plan.copies) != 0 {
+		t.Error("Expected the second sync to copy nothing, got ", plan.copies)
+	}
+}
@@ -0,0 +1,40 @@
+package common
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// CancelGracePeriod is how long a canceled transfer waits for the peer to
+// answer the message in flight before its connection is closed
+const CancelGracePeriod = 5 * time.Second
+
+// CloseOnCancel closes c once ctx is done and grace has passed, which
+// interrupts reads and writes stuck on c.  The returned function stops
+// watching ctx
+func CloseOnCancel(ctx context.Context, c io.Closer, grace time.Duration) (stop func()) {
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stopped:
+			return
+		}
+
+		timer := time.NewTimer(grace)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+			c.Close()
+		case <-stopped:
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(stopped) })
+	}
+}
@@ -0,0 +1,40 @@
+package common
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type closeRecorder chan bool
+
+func (c closeRecorder) Close() error {
+	c <- true
+	return nil
+}
+
+func TestCloseOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	closed := make(closeRecorder, 1)
+	stop := CloseOnCancel(ctx, closed, 10*time.Millisecond)
+	defer stop()
+
+	cancel()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the closer to be closed after the grace period")
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	closed = make(closeRecorder, 1)
+	stop = CloseOnCancel(ctx, closed, 50*time.Millisecond)
+
+	cancel()
+	stop()
+	select {
+	case <-closed:
+		t.Error("A closer should not be closed once watching stopped")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
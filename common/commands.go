@@ -0,0 +1,274 @@
+package common
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// subcommand is a mode of ucp with options of its own
+type subcommand struct {
+	// args describes the arguments that follow the options
+	args    string
+	summary string
+	// addFlags adds the options of the subcommand besides the log options
+	addFlags func(fs *flag.FlagSet, flags *Flags)
+	// setArgs stores the arguments that follow the options in flags
+	setArgs func(flags *Flags, args []string) error
+}
+
+var subcommands = map[string]subcommand{
+	"cp": {
+		args:    "source destination",
+		summary: "Copy a file, remote files are user@host[:port]:path and - is stdin or stdout",
+		addFlags: func(fs *flag.FlagSet, flags *Flags) {
+			addConnectFlags(fs, flags)
+			addCopyFlags(fs, flags)
+		},
+		setArgs: setCopyArgs,
+	},
+	"sync": {
+		args:    "source destination",
+		summary: "Make the destination directory look like the source directory, only copying files that differ",
+		addFlags: func(fs *flag.FlagSet, flags *Flags) {
+			addConnectFlags(fs, flags)
+			addCopyFlags(fs, flags)
+			addSyncFlags(fs, flags)
+		},
+		setArgs: func(flags *Flags, args []string) error {
+			flags.Sync = true
+			return setCopyArgs(flags, args)
+		},
+	},
+	"serve": {
+		summary: "Run a server",
+		addFlags: func(fs *flag.FlagSet, flags *Flags) {
+			fs.Var(&flags.Limit, "limit", "Maximum transfer rate in bytes per second shared by all sessions with optional K, M or G suffix, e.g. 200M")
+			addServerFlags(fs, flags)
+		},
+		setArgs: func(flags *Flags, args []string) error {
+			flags.IsServer = true
+			return noArgs(args)
+		},
+	},
+	"keygen": {
+		summary: "Generate the key pair that encrypts communication",
+		addFlags: func(fs *flag.FlagSet, flags *Flags) {
+			addPrivateKeyFlag(fs, flags)
+			addPublicKeyFlag(fs, flags)
+		},
+		setArgs: func(flags *Flags, args []string) error {
+			flags.GenerateKeys = true
+			return noArgs(args)
+		},
+	},
+	"admin": {
+		args:     "[sessions | cancel <session id>]",
+		summary:  "List or cancel the sessions of the server on this host",
+		addFlags: addAdminSocketFlag,
+		setArgs: func(flags *Flags, args []string) error {
+			flags.Admin = append([]string{}, args...)
+			return nil
+		},
+	},
+	"ls": {
+		args:    "user@host[:port]:path...",
+		summary: "List remote directories",
+		addFlags: func(fs *flag.FlagSet, flags *Flags) {
+			addConnectFlags(fs, flags)
+			fs.BoolVar(&flags.Long, "l", false, "List the mode, size and modification time of each file")
+		},
+		setArgs: commandArgs("ls"),
+	},
+	"stat": {
+		args:     "user@host[:port]:path...",
+		summary:  "Describe remote files or directories",
+		addFlags: addConnectFlags,
+		setArgs:  commandArgs("stat"),
+	},
+	"mkdir": {
+		args:    "user@host[:port]:path...",
+		summary: "Create remote directories",
+		addFlags: func(fs *flag.FlagSet, flags *Flags) {
+			addConnectFlags(fs, flags)
+			fs.BoolVar(&flags.Parents, "p", false, "Create missing parent directories, existing directories are not an error")
+		},
+		setArgs: commandArgs("mkdir"),
+	},
+	"rm": {
+		args:     "user@host[:port]:path...",
+		summary:  "Remove remote files or empty directories",
+		addFlags: addConnectFlags,
+		setArgs:  commandArgs("rm"),
+	},
+	"mv": {
+		args:     "user@host[:port]:path user@host[:port]:newpath",
+		summary:  "Move a remote file or directory on its server",
+		addFlags: addConnectFlags,
+		setArgs:  commandArgs("mv"),
+	},
+	"shell": {
+		args:     "user@host[:port]",
+		summary:  "Manage and copy the files of a server at an interactive prompt",
+		addFlags: addConnectFlags,
+		setArgs:  commandArgs("shell"),
+	},
+}
+
+// commandPaths holds the number of paths of subcommands managing remote files
+// that don't take one or more
+var commandPaths = map[string]int{
+	"mv":    2,
+	"shell": 1,
+}
+
+func setCopyArgs(flags *Flags, args []string) error {
+	if len(args) != 2 {
+		return errors.New(invalidArguments)
+	}
+
+	flags.From, flags.To = args[0], args[1]
+	return nil
+}
+
+func noArgs(args []string) error {
+	if len(args) > 0 {
+		return errors.New("Unexpected argument " + args[0])
+	}
+	return nil
+}
+
+// commandArgs returns the setArgs of the subcommand name that manages remote
+// files
+func commandArgs(name string) func(flags *Flags, args []string) error {
+	return func(flags *Flags, args []string) error {
+		flags.Command = append([]string{name}, args...)
+		return nil
+	}
+}
+
+// newFlagSet returns the options of the subcommand name with the log options
+// every subcommand takes
+func newFlagSet(name string, flags *Flags) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	// errors and help are returned to the caller
+	fs.SetOutput(io.Discard)
+
+	fs.StringVar(&flags.LogLevel, "verbosity", logWarn, "Log level. DEBUG|INFO|WARN|ERROR")
+	fs.StringVar(&flags.LogFormat, "log-format", LogFormatText, "Log line format. text|json")
+	fs.StringVar(&flags.LogOutput, "log-output", LogOutputStderr, "Where log lines are written. stderr, syslog or the path of a file")
+
+	return fs
+}
+
+// addConnectFlags adds the options of subcommands that connect to servers
+func addConnectFlags(fs *flag.FlagSet, flags *Flags) {
+	addPrivateKeyFlag(fs, flags)
+	fs.StringVar(&flags.Compress, "compress", CompressNone, "Compress file data before it is sent. none|gzip|auto, auto only compresses data that shrinks")
+	fs.Var(&flags.Limit, "limit", "Maximum transfer rate in bytes per second with optional K, M or G suffix, e.g. 200M")
+}
+
+// addCopyFlags adds the options of cp and sync
+func addCopyFlags(fs *flag.FlagSet, flags *Flags) {
+	fs.BoolVar(&flags.Preserve, "p", false, "Preserve mode bits and access and modification times")
+	fs.BoolVar(&flags.PreserveOwner, "preserve-owner", false, "Preserve uid and gid, only applied when the destination runs as root. Servers give files to the user that uploads them. Implies -p")
+	fs.BoolVar(&flags.PreserveXattrs, "preserve-xattrs", false, "Preserve extended attributes. Implies -p")
+	fs.BoolVar(&flags.Delta, "delta", false, "If the destination exists only send the parts of the file that changed")
+	fs.BoolVar(&flags.Relay, "relay", false, "When both files are remote copy through this client instead of having the source server send the file directly")
+	fs.StringVar(&flags.Progress, "progress", ProgressAuto, "How to report progress. auto|bar|json|none, auto shows a bar if stdout is a terminal")
+	fs.IntVar(&flags.Retries, "retries", 3, "How many times to reconnect and continue a copy after its connection failed, waiting longer before each attempt")
+}
+
+// addSyncFlags adds the options only sync takes
+func addSyncFlags(fs *flag.FlagSet, flags *Flags) {
+	fs.BoolVar(&flags.Delete, "delete", false, "Delete destination files that are not in the source")
+	fs.BoolVar(&flags.DryRun, "dry-run", false, "Print what would be copied and deleted without doing it")
+	fs.BoolVar(&flags.Checksum, "checksum", false, "Compare files by checksum instead of size and modification time")
+}
+
+// addServerFlags adds the options of serve but -limit, which clients take too
+func addServerFlags(fs *flag.FlagSet, flags *Flags) {
+	fs.IntVar(&flags.Port, "port", DefaultPort, "The port that the ucp server listens on")
+	fs.StringVar(&flags.Host, "host", "127.0.0.1", "The host or interface the server listens on")
+	fs.Var(&flags.UserLimit, "user-limit", "Maximum transfer rate shared by all sessions of one user, e.g. 50M")
+	fs.StringVar(&flags.AuditLog, "audit-log", "", "File that a JSON line describing each transfer is appended to")
+	addAdminSocketFlag(fs, flags)
+	fs.StringVar(&flags.MetricsAddress, "metrics-address", "", "Address such as :9192 to serve Prometheus metrics on at /metrics, no metrics if empty")
+	fs.StringVar(&flags.Config, "config", "", "File of verbosity, limit, user-limit, session limit and timeout settings, reread on SIGHUP")
+	fs.DurationVar(&flags.ShutdownTimeout, "shutdown-timeout", 30*time.Second, "How long SIGTERM or SIGINT waits for transfers in progress before cancelling them")
+	fs.IntVar(&flags.MaxSessions, "max-sessions", 0, "Maximum number of sessions at once, 0 for no limit")
+	fs.IntVar(&flags.MaxUserSessions, "max-user-sessions", 0, "Maximum number of sessions of one user at once, 0 for no limit")
+	fs.DurationVar(&flags.HandshakeTimeout, "handshake-timeout", 30*time.Second, "How long a client has to authenticate")
+	fs.DurationVar(&flags.IdleTimeout, "idle-timeout", 5*time.Minute, "Close sessions that send or receive nothing for this long")
+	fs.StringVar(&flags.S3Bucket, "s3-bucket", "", "Store files in this S3 bucket below a prefix for each user instead of the local file system. Credentials are read from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	fs.StringVar(&flags.S3Endpoint, "s3-endpoint", "https://s3.amazonaws.com", "URL of the S3 compatible service holding -s3-bucket")
+	fs.StringVar(&flags.S3Region, "s3-region", "us-east-1", "Region of -s3-bucket")
+}
+
+func addAdminSocketFlag(fs *flag.FlagSet, flags *Flags) {
+	fs.StringVar(&flags.AdminSocket, "admin-socket", getDefaultKeyPath("admin.sock"), "Unix socket the server serves its admin API on and ucp admin connects to, no admin API if empty")
+}
+
+func addPrivateKeyFlag(fs *flag.FlagSet, flags *Flags) {
+	fs.StringVar(&flags.PrivateKeyPath, "private-key-path", DefaultPrivateKeyPath(), "Path to private key")
+}
+
+func addPublicKeyFlag(fs *flag.FlagSet, flags *Flags) {
+	fs.StringVar(&flags.PublicKeyPath, "public-key-path", getDefaultKeyPath("key.pub"), "Path to public key")
+}
+
+// flagSet returns the options of the subcommand name stored in flags
+func (c subcommand) flagSet(name string, flags *Flags) *flag.FlagSet {
+	fs := newFlagSet(name, flags)
+	c.addFlags(fs, flags)
+	return fs
+}
+
+// help describes how the subcommand name is used with the options in fs
+func (c subcommand) help(name string, fs *flag.FlagSet) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "usage: ucp %s [options] %s\n\n%s\n\nOptions:\n", name, c.args, c.summary)
+
+	fs.SetOutput(&b)
+	fs.PrintDefaults()
+	fs.SetOutput(io.Discard)
+
+	return b.String()
+}
+
+// usage lists the subcommands
+func usage() string {
+	var names []string
+	for name := range subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("usage: ucp <subcommand> [options] [arguments]\n\nSubcommands:\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "  %-8s %s\n", name, subcommands[name].summary)
+	}
+	b.WriteString("\nucp help <subcommand> describes its options.  The older form with options\n")
+	b.WriteString("only, such as ucp -from source -to destination, still works\n")
+
+	return b.String()
+}
+
+// helpError returns the help ucp help args asks for
+func helpError(args []string) error {
+	if len(args) == 0 {
+		return &UsageError{flag.ErrHelp, usage()}
+	}
+
+	command, ok := subcommands[args[0]]
+	if !ok {
+		return &UsageError{errors.New("Unknown subcommand " + args[0]), usage()}
+	}
+
+	return &UsageError{flag.ErrHelp, command.help(args[0], command.flagSet(args[0], &Flags{}))}
+}
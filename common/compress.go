@@ -0,0 +1,57 @@
+package common
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+
+	"github.com/murphybytes/ucp/wire"
+)
+
+// CompressionMethods maps the names of the -compress flag to the compression
+// they request
+var CompressionMethods = map[string]wire.Compression{
+	CompressNone: wire.NoCompression,
+	CompressGzip: wire.GzipCompression,
+	CompressAuto: wire.AutoCompression,
+}
+
+// CompressChunk compresses a packet of file data with method.  It returns the
+// bytes to send and whether they are compressed, with AutoCompression data
+// that doesn't get smaller is returned as is
+func CompressChunk(method wire.Compression, data []byte) (out []byte, compressed bool, e error) {
+	if method == wire.NoCompression {
+		return data, false, nil
+	}
+
+	var buffer bytes.Buffer
+	var writer *gzip.Writer
+	if writer, e = gzip.NewWriterLevel(&buffer, gzip.BestSpeed); e != nil {
+		return
+	}
+
+	if _, e = writer.Write(data); e != nil {
+		return
+	}
+
+	if e = writer.Close(); e != nil {
+		return
+	}
+
+	if method == wire.AutoCompression && buffer.Len() >= len(data) {
+		return data, false, nil
+	}
+
+	return buffer.Bytes(), true, nil
+}
+
+// DecompressChunk reverses CompressChunk
+func DecompressChunk(data []byte) (out []byte, e error) {
+	var reader *gzip.Reader
+	if reader, e = gzip.NewReader(bytes.NewReader(data)); e != nil {
+		return
+	}
+	defer reader.Close()
+
+	return ioutil.ReadAll(reader)
+}
@@ -0,0 +1,49 @@
+package common
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"github.com/murphybytes/ucp/wire"
+)
+
+func TestCompressChunk(t *testing.T) {
+	text := bytes.Repeat([]byte("text compresses well "), 1000)
+
+	out, compressed, err := CompressChunk(wire.AutoCompression, text)
+	if err != nil {
+		t.Fatal("CompressChunk failed -", err.Error())
+	}
+
+	if !compressed || len(out) >= len(text) {
+		t.Fatal("Expected text to be compressed")
+	}
+
+	var decompressed []byte
+	if decompressed, err = DecompressChunk(out); err != nil {
+		t.Fatal("DecompressChunk failed -", err.Error())
+	}
+
+	if !bytes.Equal(decompressed, text) {
+		t.Error("Decompressed data doesn't match original")
+	}
+}
+
+func TestAutoCompressionSkipsRandomData(t *testing.T) {
+	random := make([]byte, 4096)
+	rand.Read(random)
+
+	out, compressed, _ := CompressChunk(wire.AutoCompression, random)
+	if compressed || !bytes.Equal(out, random) {
+		t.Error("Random data should be sent uncompressed")
+	}
+
+	if _, compressed, _ = CompressChunk(wire.GzipCompression, random); !compressed {
+		t.Error("Gzip compression should always compress")
+	}
+
+	if _, compressed, _ = CompressChunk(wire.NoCompression, random); compressed {
+		t.Error("Data shouldn't be compressed without compression")
+	}
+}
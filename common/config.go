@@ -0,0 +1,97 @@
+package common
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LoadConfig reads server settings from the file at path into flags.  Each
+// line holds the name of a command line option and its value, blank lines and
+// lines starting with # are ignored.  Only the options that can change while
+// the server is running are allowed, verbosity, limit, user-limit,
+// shutdown-timeout, max-sessions, max-user-sessions, handshake-timeout and
+// idle-timeout
+func LoadConfig(path string, flags *Flags) (e error) {
+	var f *os.File
+	if f, e = os.Open(path); e != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return fmt.Errorf("%s:%d: expected an option and a value", path, lineNumber)
+		}
+
+		if e = setConfigOption(flags, fields[0], fields[1]); e != nil {
+			return fmt.Errorf("%s:%d: %s", path, lineNumber, e.Error())
+		}
+	}
+
+	return scanner.Err()
+}
+
+func setConfigOption(flags *Flags, name, value string) (e error) {
+	switch name {
+	case "verbosity":
+		level := strings.ToUpper(value)
+		if !validLogLevel(level) {
+			return errors.New(invalidLogLevel)
+		}
+		flags.LogLevel = level
+	case "limit":
+		return flags.Limit.Set(value)
+	case "user-limit":
+		return flags.UserLimit.Set(value)
+	case "shutdown-timeout":
+		return setDuration(&flags.ShutdownTimeout, value)
+	case "handshake-timeout":
+		return setDuration(&flags.HandshakeTimeout, value)
+	case "idle-timeout":
+		return setDuration(&flags.IdleTimeout, value)
+	case "max-sessions":
+		return setCount(&flags.MaxSessions, value)
+	case "max-user-sessions":
+		return setCount(&flags.MaxUserSessions, value)
+	default:
+		return errors.New("unknown option " + name)
+	}
+
+	return
+}
+
+func setDuration(d *time.Duration, value string) (e error) {
+	var parsed time.Duration
+	if parsed, e = time.ParseDuration(value); e != nil {
+		return
+	}
+
+	*d = parsed
+	return
+}
+
+func setCount(n *int, value string) (e error) {
+	var parsed int
+	if parsed, e = strconv.Atoi(value); e != nil {
+		return
+	}
+
+	if parsed < 0 {
+		return errors.New("value can't be negative")
+	}
+
+	*n = parsed
+	return
+}
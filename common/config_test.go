@@ -0,0 +1,63 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, contents string) string {
+	f, err := ioutil.TempFile("", "ucp-config")
+	if err != nil {
+		t.Fatal("Could not create config file ", err.Error())
+	}
+	defer f.Close()
+
+	if _, err = f.WriteString(contents); err != nil {
+		t.Fatal("Could not write config file ", err.Error())
+	}
+
+	return f.Name()
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, "# server settings\n\nverbosity info\nlimit 10M\nuser-limit 1M\nshutdown-timeout 5s\nmax-sessions 20\nidle-timeout 1m\n")
+	defer os.Remove(path)
+
+	flags := Flags{LogLevel: logWarn}
+	if err := LoadConfig(path, &flags); err != nil {
+		t.Fatal("Unexpected error ", err.Error())
+	}
+
+	if flags.LogLevel != logInfo {
+		t.Error("Expected verbosity INFO got ", flags.LogLevel)
+	}
+
+	if flags.Limit != 10*1024*1024 || flags.UserLimit != 1024*1024 {
+		t.Error("Unexpected limits ", flags.Limit.String(), " ", flags.UserLimit.String())
+	}
+
+	if flags.ShutdownTimeout != 5*time.Second {
+		t.Error("Expected shutdown timeout 5s got ", flags.ShutdownTimeout)
+	}
+
+	if flags.MaxSessions != 20 || flags.IdleTimeout != time.Minute {
+		t.Error("Unexpected session settings ", flags.MaxSessions, " ", flags.IdleTimeout)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	for _, contents := range []string{"port 80\n", "limit\n", "limit fast\n", "verbosity loud\n", "max-sessions -1\n"} {
+		path := writeConfig(t, contents)
+		err := LoadConfig(path, &Flags{})
+		os.Remove(path)
+
+		if err == nil {
+			t.Error("Expected an error for ", contents)
+		} else if !strings.HasPrefix(err.Error(), path+":1:") {
+			t.Error("Expected error to name the line, got ", err.Error())
+		}
+	}
+}
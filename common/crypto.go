@@ -8,14 +8,17 @@ import (
 	"crypto/md5"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/gob"
 	"encoding/pem"
 	"errors"
+	"fmt"
 	"hash"
 	"io/ioutil"
 	"os"
+	"strings"
 )
 
 // Defines key sizes and initialization vector size for AES
@@ -28,6 +31,29 @@ const (
 // KeyBufferFetcher returns an array of bytes containing a crypto key
 type KeyBufferFetcher func(*Flags) ([]byte, error)
 
+// Keygen generates the key pair of ucp keygen
+type Keygen struct {
+	flags *Flags
+}
+
+// NewKeygen creates a Keygen
+func NewKeygen(flags *Flags) Application {
+	return &Keygen{flags: flags}
+}
+
+// Run generates the keys and tells where they were written
+func (k *Keygen) Run() (e error) {
+	if e = ucpKeyGenerate(k.flags.PrivateKeyPath, k.flags.PublicKeyPath); e != nil {
+		return fmt.Errorf("Key generation failed - %w", e)
+	}
+
+	fmt.Println("Key generation successful")
+	fmt.Println("Public key ->", k.flags.PublicKeyPath)
+	fmt.Println("Private key ->", k.flags.PrivateKeyPath)
+
+	return
+}
+
 // generates public/private keys and write each to file
 func ucpKeyGenerate(privateKeyPath, publicKeyPath string) (e error) {
 	var privateKey *rsa.PrivateKey
@@ -83,6 +109,63 @@ func CreateBase64EncodedPublicKey(key rsa.PublicKey) (encodedKey []byte, e error
 	return
 }
 
+// ReadAuthorizedKeys returns the public keys in an authorized_keys file, one
+// key as written by CreateBase64EncodedPublicKey per line
+func ReadAuthorizedKeys(path string) (keys []rsa.PublicKey, e error) {
+	var buff []byte
+	if buff, e = ioutil.ReadFile(path); e != nil {
+		return
+	}
+
+	for _, line := range strings.Split(string(buff), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var decoded []byte
+		if decoded, e = base64.StdEncoding.DecodeString(line); e != nil {
+			return nil, e
+		}
+
+		var key rsa.PublicKey
+		if e = gob.NewDecoder(bytes.NewBuffer(decoded)).Decode(&key); e != nil {
+			return nil, e
+		}
+
+		keys = append(keys, key)
+	}
+
+	return
+}
+
+// PublicKeyFingerprint returns the SHA-256 fingerprint of a public key
+func PublicKeyFingerprint(key *rsa.PublicKey) string {
+	sum := sha256.Sum256(x509.MarshalPKCS1PublicKey(key))
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// challengeDigest returns what a client signs to authenticate as userName.
+// The prefix keeps the signature from being valid for anything but ucp
+// authentication
+func challengeDigest(challenge []byte, userName string) []byte {
+	digest := sha256.New()
+	digest.Write([]byte("ucp authentication\x00"))
+	digest.Write(challenge)
+	digest.Write([]byte(userName))
+	return digest.Sum(nil)
+}
+
+// SignChallenge proves that the client connecting as userName holds key
+func SignChallenge(key *rsa.PrivateKey, challenge []byte, userName string) ([]byte, error) {
+	return rsa.SignPSS(rand.Reader, key, crypto.SHA256, challengeDigest(challenge, userName), nil)
+}
+
+// VerifyChallenge checks a signature made by SignChallenge
+func VerifyChallenge(key *rsa.PublicKey, challenge []byte, userName string, signature []byte) error {
+	return rsa.VerifyPSS(key, crypto.SHA256, challengeDigest(challenge, userName), signature, nil)
+}
+
 // GetPrivateKey returns a private key
 func GetPrivateKey(privateKeyPath string) (key *rsa.PrivateKey, e error) {
 
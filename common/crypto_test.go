@@ -7,6 +7,7 @@ import (
 	"crypto/rsa"
 	"encoding/gob"
 	"fmt"
+	"io/ioutil"
 	"math/big"
 	"os"
 	"testing"
@@ -149,3 +150,92 @@ func TestEncryptionWithMarshalling(t *testing.T) {
 	}
 
 }
+
+func TestReadAuthorizedKeys(t *testing.T) {
+	testdir, err := CreateTestDirectory()
+	if err != nil {
+		t.Fatal("Test data directory creation failed -", err.Error())
+	}
+	defer DeleteTestDirectory(testdir)
+
+	var contents []byte
+	var expected []*rsa.PrivateKey
+	for i := 0; i < 2; i++ {
+		key, err := rsa.GenerateKey(rand.Reader, 1024)
+		if err != nil {
+			t.Fatal("Key generation failed -", err.Error())
+		}
+		expected = append(expected, key)
+
+		encoded, err := CreateBase64EncodedPublicKey(key.PublicKey)
+		if err != nil {
+			t.Fatal("Key encoding failed -", err.Error())
+		}
+		contents = append(contents, "# a comment\n"...)
+		contents = append(contents, encoded...)
+	}
+
+	path := fmt.Sprint(testdir, "/authorized_keys")
+	if err = ioutil.WriteFile(path, contents, 0600); err != nil {
+		t.Fatal("Could not write authorized keys -", err.Error())
+	}
+
+	keys, err := ReadAuthorizedKeys(path)
+	if err != nil {
+		t.Fatal("ReadAuthorizedKeys failed -", err.Error())
+	}
+
+	if len(keys) != len(expected) {
+		t.Fatal("Expected ", len(expected), " keys got ", len(keys))
+	}
+
+	for i, key := range keys {
+		if key.N.Cmp(expected[i].PublicKey.N) != 0 || key.E != expected[i].PublicKey.E {
+			t.Error("Key ", i, " does not match")
+		}
+	}
+}
+
+func TestPublicKeyFingerprint(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal("Key generation failed -", err.Error())
+	}
+
+	fingerprint := PublicKeyFingerprint(&key.PublicKey)
+	if len(fingerprint) != len("SHA256:")+43 || fingerprint[:7] != "SHA256:" {
+		t.Error("Unexpected fingerprint ", fingerprint)
+	}
+
+	copied := rsa.PublicKey{N: new(big.Int).Set(key.N), E: key.E}
+	if PublicKeyFingerprint(&copied) != fingerprint {
+		t.Error("Equal keys should have equal fingerprints")
+	}
+}
+
+func TestSignChallenge(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 1024)
+	other, _ := rsa.GenerateKey(rand.Reader, 1024)
+	challenge := []byte("0123456789abcdef0123456789abcdef")
+
+	signature, err := SignChallenge(key, challenge, "alice")
+	if err != nil {
+		t.Fatal("SignChallenge failed -", err.Error())
+	}
+
+	if err = VerifyChallenge(&key.PublicKey, challenge, "alice", signature); err != nil {
+		t.Error("Expected the signature to be valid -", err.Error())
+	}
+
+	if VerifyChallenge(&other.PublicKey, challenge, "alice", signature) == nil {
+		t.Error("Expected the signature of another key to be rejected")
+	}
+
+	if VerifyChallenge(&key.PublicKey, challenge, "bob", signature) == nil {
+		t.Error("Expected the signature for another user to be rejected")
+	}
+
+	if VerifyChallenge(&key.PublicKey, []byte("another challenge"), "alice", signature) == nil {
+		t.Error("Expected the signature of another challenge to be rejected")
+	}
+}
@@ -0,0 +1,216 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/murphybytes/ucp/wire"
+)
+
+// rollingChecksum is the rsync weak checksum, it can be moved along a buffer
+// one byte at a time without looking at the bytes in between
+type rollingChecksum struct {
+	a, b uint32
+	size uint32
+}
+
+func newRollingChecksum(block []byte) (r rollingChecksum) {
+	r.size = uint32(len(block))
+	for i, c := range block {
+		r.a += uint32(c)
+		r.b += (r.size - uint32(i)) * uint32(c)
+	}
+	return
+}
+
+func (r *rollingChecksum) roll(out, in byte) {
+	r.a += uint32(in) - uint32(out)
+	r.b += r.a - r.size*uint32(out)
+}
+
+func (r *rollingChecksum) sum() uint32 {
+	return r.a&0xffff | r.b<<16
+}
+
+func strongChecksum(block []byte) []byte {
+	sum := md5.Sum(block)
+	return sum[:]
+}
+
+// ComputeSignatures reads r to the end and returns a signature for each block
+// of blockSize bytes
+func ComputeSignatures(r io.Reader, blockSize int) (signatures []wire.BlockSignature, e error) {
+	block := make([]byte, blockSize)
+	for {
+		var read int
+		read, e = io.ReadFull(r, block)
+		if read > 0 {
+			rolling := newRollingChecksum(block[:read])
+			signatures = append(signatures, wire.BlockSignature{
+				Weak:   rolling.sum(),
+				Strong: strongChecksum(block[:read]),
+			})
+		}
+
+		if e == io.EOF || e == io.ErrUnexpectedEOF {
+			return signatures, nil
+		}
+
+		if e != nil {
+			return
+		}
+	}
+}
+
+// DeltaEncoder reads a source file and produces the operations that rebuild it
+// from the receiver's copy described by a set of block signatures
+type DeltaEncoder struct {
+	reader     *bufio.Reader
+	blockSize  int
+	signatures []wire.BlockSignature
+	index      map[uint32][]int
+	rolling    rollingChecksum
+	rolled     bool
+}
+
+// NewDeltaEncoder creates a DeltaEncoder that reads the source from r
+func NewDeltaEncoder(r io.Reader, signatures []wire.BlockSignature, blockSize int) *DeltaEncoder {
+	index := make(map[uint32][]int)
+	for i, signature := range signatures {
+		index[signature.Weak] = append(index[signature.Weak], i)
+	}
+
+	return &DeltaEncoder{
+		reader:     bufio.NewReaderSize(r, 4*blockSize),
+		blockSize:  blockSize,
+		signatures: signatures,
+		index:      index,
+	}
+}
+
+func (d *DeltaEncoder) match(window []byte) int {
+	candidates, ok := d.index[d.rolling.sum()]
+	if !ok {
+		return -1
+	}
+
+	strong := strongChecksum(window)
+	for _, i := range candidates {
+		if bytes.Equal(d.signatures[i].Strong, strong) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Next returns the next batch of operations, no more than
+// wire.MaxDeltaOpsPerPacket operations carrying no more than
+// wire.MaxDeltaLiteralSize literal bytes.  It returns io.EOF once the whole
+// source has been encoded
+func (d *DeltaEncoder) Next() (ops []wire.DeltaOp, e error) {
+	var literal []byte
+	flush := func() {
+		if len(literal) > 0 {
+			ops = append(ops, wire.DeltaOp{BlockIndex: -1, Literal: literal})
+			literal = nil
+		}
+	}
+
+	for len(ops) < wire.MaxDeltaOpsPerPacket-1 && len(literal) < wire.MaxDeltaLiteralSize {
+		window, err := d.reader.Peek(d.blockSize)
+		if len(window) < d.blockSize {
+			if err != io.EOF {
+				return nil, err
+			}
+
+			if len(window) == 0 {
+				break
+			}
+
+			// what is left is shorter than a block so it can only be sent as is
+			count := len(window)
+			if room := wire.MaxDeltaLiteralSize - len(literal); count > room {
+				count = room
+			}
+			literal = append(literal, window[:count]...)
+			d.reader.Discard(count)
+			continue
+		}
+
+		if !d.rolled {
+			d.rolling = newRollingChecksum(window)
+			d.rolled = true
+		}
+
+		if i := d.match(window); i >= 0 {
+			flush()
+			ops = append(ops, wire.DeltaOp{BlockIndex: i})
+			d.reader.Discard(d.blockSize)
+			d.rolled = false
+			continue
+		}
+
+		out := window[0]
+		literal = append(literal, out)
+		d.reader.Discard(1)
+
+		if next, _ := d.reader.Peek(d.blockSize); len(next) == d.blockSize {
+			d.rolling.roll(out, next[d.blockSize-1])
+		} else {
+			d.rolled = false
+		}
+	}
+
+	flush()
+
+	if len(ops) == 0 {
+		return nil, io.EOF
+	}
+
+	return
+}
+
+// ApplyDelta writes the data described by ops to w, reading referenced blocks
+// from basis.  blocks is the number of blocks the signatures of basis
+// described, only the last of them may be shorter than blockSize
+func ApplyDelta(basis io.ReaderAt, blockSize, blocks int, ops []wire.DeltaOp, w io.Writer) (e error) {
+	block := make([]byte, blockSize)
+	for _, op := range ops {
+		if op.BlockIndex < 0 {
+			if _, e = w.Write(op.Literal); e != nil {
+				return
+			}
+			continue
+		}
+
+		if basis == nil {
+			return errors.New("Delta references a block but there is no existing file")
+		}
+
+		if op.BlockIndex >= blocks {
+			return fmt.Errorf("Delta references block %d of a file with %d blocks", op.BlockIndex, blocks)
+		}
+
+		var read int
+		if read, e = basis.ReadAt(block, int64(op.BlockIndex)*int64(blockSize)); e != nil && e != io.EOF {
+			return
+		}
+
+		// a shorter block means the existing file changed since its
+		// signatures were computed
+		if read == 0 || (read < blockSize && op.BlockIndex < blocks-1) {
+			return fmt.Errorf("Block %d of the existing file is shorter than its signature, the file changed during the transfer", op.BlockIndex)
+		}
+
+		if _, e = w.Write(block[:read]); e != nil {
+			return
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package common
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/murphybytes/ucp/wire"
+)
+
+func TestRollingChecksum(t *testing.T) {
+	buffer := make([]byte, 256)
+	rand.Read(buffer)
+
+	rolling := newRollingChecksum(buffer[:64])
+	for i := 1; i+64 <= len(buffer); i++ {
+		rolling.roll(buffer[i-1], buffer[i+63])
+		expected := newRollingChecksum(buffer[i : i+64])
+		if rolling.sum() != expected.sum() {
+			t.Fatal("Rolled checksum doesn't match at offset ", i)
+		}
+	}
+}
+
+func encodeAndApply(t *testing.T, basis, source []byte) (rebuilt []byte, literalBytes int) {
+	signatures, err := ComputeSignatures(bytes.NewReader(basis), wire.DeltaBlockSize)
+	if err != nil {
+		t.Fatal("ComputeSignatures failed -", err.Error())
+	}
+
+	var output bytes.Buffer
+	encoder := NewDeltaEncoder(bytes.NewReader(source), signatures, wire.DeltaBlockSize)
+	for {
+		ops, err := encoder.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal("Next failed -", err.Error())
+		}
+
+		for _, op := range ops {
+			literalBytes += len(op.Literal)
+		}
+
+		if err = ApplyDelta(bytes.NewReader(basis), wire.DeltaBlockSize, len(signatures), ops, &output); err != nil {
+			t.Fatal("ApplyDelta failed -", err.Error())
+		}
+	}
+
+	return output.Bytes(), literalBytes
+}
+
+func TestDeltaOfChangedFile(t *testing.T) {
+	basis := make([]byte, 50*wire.DeltaBlockSize+123)
+	rand.Read(basis)
+
+	// insert bytes near the start and overwrite some near the end
+	source := append([]byte{}, basis[:1000]...)
+	source = append(source, []byte("inserted bytes")...)
+	source = append(source, basis[1000:]...)
+	copy(source[40*wire.DeltaBlockSize:], []byte("overwritten"))
+
+	rebuilt, literalBytes := encodeAndApply(t, basis, source)
+	if !bytes.Equal(rebuilt, source) {
+		t.Fatal("Rebuilt file doesn't match source")
+	}
+
+	if literalBytes > 4*wire.DeltaBlockSize {
+		t.Error("Too many literal bytes sent for a small change ", literalBytes)
+	}
+}
+
+func TestDeltaWithoutBasis(t *testing.T) {
+	source := make([]byte, 3*wire.MaxDeltaLiteralSize+17)
+	rand.Read(source)
+
+	rebuilt, literalBytes := encodeAndApply(t, nil, source)
+	if !bytes.Equal(rebuilt, source) {
+		t.Fatal("Rebuilt file doesn't match source")
+	}
+
+	if literalBytes != len(source) {
+		t.Error("Expected whole file to be sent as literal, sent ", literalBytes)
+	}
+}
+
+func TestApplyDeltaRejectsMissingBlocks(t *testing.T) {
+	basis := make([]byte, 2*wire.DeltaBlockSize+10)
+	rand.Read(basis)
+
+	var output bytes.Buffer
+	ops := []wire.DeltaOp{{BlockIndex: 3}}
+	if err := ApplyDelta(bytes.NewReader(basis), wire.DeltaBlockSize, 3, ops, &output); err == nil {
+		t.Error("Expected a block past the end of the file to fail")
+	}
+
+	// the file lost its last block after its signatures were computed
+	ops = []wire.DeltaOp{{BlockIndex: 2}}
+	if err := ApplyDelta(bytes.NewReader(basis[:2*wire.DeltaBlockSize]), wire.DeltaBlockSize, 3, ops, &output); err == nil {
+		t.Error("Expected a missing block to fail")
+	}
+
+	ops = []wire.DeltaOp{{BlockIndex: 0}}
+	if err := ApplyDelta(bytes.NewReader(basis[:10]), wire.DeltaBlockSize, 3, ops, &output); err == nil {
+		t.Error("Expected a short block that isn't the last to fail")
+	}
+
+	output.Reset()
+	ops = []wire.DeltaOp{{BlockIndex: 2}}
+	if err := ApplyDelta(bytes.NewReader(basis), wire.DeltaBlockSize, 3, ops, &output); err != nil {
+		t.Fatal("ApplyDelta failed -", err.Error())
+	}
+
+	if !bytes.Equal(output.Bytes(), basis[2*wire.DeltaBlockSize:]) {
+		t.Error("Expected the short last block to be copied")
+	}
+}
@@ -0,0 +1,110 @@
+package common
+
+import (
+	"errors"
+	"os"
+	"syscall"
+
+	"github.com/murphybytes/ucp/wire"
+)
+
+// Errors for the failures a server reports with their own wire.ResponseCode.
+// The errors clients return for such replies wrap them, so they can be tested
+// with errors.Is, and servers report errors that wrap them with their code
+var (
+	ErrNotFound         = errors.New("Not found")
+	ErrPermissionDenied = errors.New("Permission denied")
+	ErrDiskFull         = errors.New("Disk full")
+	ErrAuthFailed       = errors.New("Authentication failed")
+	ErrQuotaExceeded    = errors.New("Quota exceeded")
+	ErrPathNotAllowed   = errors.New("Path not allowed")
+	ErrVersionMismatch  = errors.New("Protocol version mismatch")
+	ErrBusy             = errors.New("Server busy")
+	ErrCanceled         = errors.New("Canceled")
+)
+
+var codeErrors = map[wire.ResponseCode]error{
+	wire.NotFound:         ErrNotFound,
+	wire.PermissionDenied: ErrPermissionDenied,
+	wire.DiskFull:         ErrDiskFull,
+	wire.AuthFailed:       ErrAuthFailed,
+	wire.QuotaExceeded:    ErrQuotaExceeded,
+	wire.PathNotAllowed:   ErrPathNotAllowed,
+	wire.VersionMismatch:  ErrVersionMismatch,
+	wire.Busy:             ErrBusy,
+	wire.Canceled:         ErrCanceled,
+}
+
+// exitCodes are the exit codes of ucp for each class of error.  Other errors
+// exit with 1, invalid flags with 2
+var exitCodes = map[wire.ResponseCode]int{
+	wire.NotFound:         3,
+	wire.PermissionDenied: 4,
+	wire.PathNotAllowed:   5,
+	wire.AuthFailed:       6,
+	wire.DiskFull:         7,
+	wire.QuotaExceeded:    8,
+	wire.VersionMismatch:  9,
+	wire.Busy:             10,
+	wire.Canceled:         11,
+}
+
+// ResponseCode classifies err for the reply that reports it, wire.Error if it
+// has no code of its own
+func ResponseCode(err error) wire.ResponseCode {
+	switch {
+	case errors.Is(err, syscall.ENOSPC):
+		return wire.DiskFull
+	case errors.Is(err, syscall.EDQUOT):
+		return wire.QuotaExceeded
+	case errors.Is(err, os.ErrNotExist):
+		return wire.NotFound
+	case errors.Is(err, os.ErrPermission):
+		return wire.PermissionDenied
+	}
+
+	for code, sentinel := range codeErrors {
+		if errors.Is(err, sentinel) {
+			return code
+		}
+	}
+
+	return wire.Error
+}
+
+// StatusError returns the error a reply with code and text reports.  It wraps
+// the error for code if there is one
+func StatusError(code wire.ResponseCode, text string) error {
+	return &statusError{code: code, text: text}
+}
+
+type statusError struct {
+	code wire.ResponseCode
+	text string
+}
+
+func (e *statusError) Error() string {
+	return e.text
+}
+
+func (e *statusError) Unwrap() error {
+	return codeErrors[e.code]
+}
+
+// ExitCode returns the exit code of ucp for err, 0 if it is nil
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var usage *UsageError
+	if errors.As(err, &usage) {
+		return 2
+	}
+
+	if code, ok := exitCodes[ResponseCode(err)]; ok {
+		return code
+	}
+
+	return 1
+}
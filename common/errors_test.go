@@ -0,0 +1,64 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/murphybytes/ucp/wire"
+)
+
+func TestResponseCode(t *testing.T) {
+	_, notFound := os.Open("/does/not/exist")
+
+	codes := map[error]wire.ResponseCode{
+		notFound: wire.NotFound,
+		&os.PathError{Op: "open", Path: "/etc/shadow", Err: syscall.EACCES}: wire.PermissionDenied,
+		&os.PathError{Op: "write", Path: "/data/file", Err: syscall.ENOSPC}: wire.DiskFull,
+		&os.PathError{Op: "write", Path: "/data/file", Err: syscall.EDQUOT}: wire.QuotaExceeded,
+		fmt.Errorf("%w - Only /data is served", ErrPathNotAllowed):          wire.PathNotAllowed,
+		errors.New("Something else"):                                        wire.Error,
+	}
+
+	for err, code := range codes {
+		if ResponseCode(err) != code {
+			t.Error("Expected ", code, " for ", err, " got ", ResponseCode(err))
+		}
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	err := StatusError(wire.NotFound, "open /data/file: no such file or directory")
+	if err.Error() != "open /data/file: no such file or directory" {
+		t.Error("Expected the text of the reply got ", err.Error())
+	}
+
+	if !errors.Is(fmt.Errorf("copy file: %w", err), ErrNotFound) || errors.Is(err, ErrPermissionDenied) {
+		t.Error("Expected the error to be ErrNotFound")
+	}
+
+	if errors.Unwrap(StatusError(wire.Error, "failed")) != nil {
+		t.Error("Expected an error without a code of its own to wrap nothing")
+	}
+
+	// errors received from a server keep their code when they are reported on
+	if ResponseCode(err) != wire.NotFound {
+		t.Error("Expected the code to be kept got ", ResponseCode(err))
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	if ExitCode(nil) != 0 || ExitCode(errors.New("failed")) != 1 {
+		t.Error("Expected 0 without an error and 1 for other errors")
+	}
+
+	if code := ExitCode(StatusError(wire.AuthFailed, "Public key is not authorized")); code != 6 {
+		t.Error("Expected 6 for a failed authentication got ", code)
+	}
+
+	if _, err := os.Open("/does/not/exist"); ExitCode(err) != 3 {
+		t.Error("Expected local errors to be classified too got ", ExitCode(err))
+	}
+}
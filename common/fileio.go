@@ -1,7 +1,7 @@
 package common
 
 import (
-	"io"
+	"io/ioutil"
 	"os"
 	"os/user"
 	"path/filepath"
@@ -21,20 +21,96 @@ func getPath(filePath, userName string) (path string, e error) {
 	return
 }
 
-// Create returns an open file for writing.  If a relative path is
+// UserPath returns filePath made absolute relative to the home directory of
+// the user identified by userName
+func UserPath(filePath, userName string) (path string, e error) {
+	return getPath(filePath, userName)
+}
+
+// AtomicFile is written to a temporary file next to its destination and is
+// only renamed into place by Commit, so readers of the destination never see
+// a partially written file
+type AtomicFile struct {
+	*os.File
+	path      string
+	committed bool
+}
+
+// NewAtomicFile creates a temporary file in the same directory as path.  If
+// path already exists the temporary file gets the same permissions, otherwise
+// the permissions a new file gets from the umask
+func NewAtomicFile(path string) (f *AtomicFile, e error) {
+	mode := 0666 &^ umask()
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	dir, base := filepath.Split(path)
+
+	var file *os.File
+	if file, e = ioutil.TempFile(dir, "."+base+".ucp-"); e != nil {
+		return
+	}
+
+	// temporary files are only readable by their owner
+	if e = file.Chmod(mode); e != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return
+	}
+
+	return &AtomicFile{
+		File: file,
+		path: path,
+	}, nil
+}
+
+// Commit flushes and closes the temporary file and renames it to the
+// destination path
+func (f *AtomicFile) Commit() (e error) {
+	if e = f.File.Sync(); e != nil {
+		return
+	}
+
+	if e = f.File.Close(); e != nil {
+		return
+	}
+
+	if e = os.Rename(f.File.Name(), f.path); e != nil {
+		return
+	}
+
+	f.committed = true
+
+	return
+}
+
+// Close removes the temporary file unless Commit succeeded, leaving the
+// destination untouched
+func (f *AtomicFile) Close() (e error) {
+	if f.committed {
+		return
+	}
+
+	f.File.Close()
+
+	return os.Remove(f.File.Name())
+}
+
+// Create returns an AtomicFile for writing.  If a relative path is
 // passed in, an absolute path will be created by appending the
 // home dir belonging to user identified by userName
-func Create(path string, userName string) (f io.WriteCloser, e error) {
+func Create(path string, userName string) (f *AtomicFile, e error) {
 
 	if path, e = getPath(path, userName); e != nil {
 		return
 	}
 
-	return os.Create(path)
+	return NewAtomicFile(path)
 }
 
 // Open returns an open file for reading
-func Open(path string, userName string) (f io.ReadCloser, e error) {
+func Open(path string, userName string) (f *os.File, e error) {
 
 	if path, e = getPath(path, userName); e != nil {
 		return
@@ -0,0 +1,88 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAtomicFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ucp")
+	if err != nil {
+		t.Fatal("Temp dir creation failed -", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "file")
+	if err = ioutil.WriteFile(path, []byte("original"), 0640); err != nil {
+		t.Fatal("Write failed -", err.Error())
+	}
+
+	var f *AtomicFile
+	if f, err = NewAtomicFile(path); err != nil {
+		t.Fatal("NewAtomicFile failed -", err.Error())
+	}
+	f.Write([]byte("abandoned"))
+	f.Close()
+
+	if contents, _ := ioutil.ReadFile(path); string(contents) != "original" {
+		t.Error("Destination should be untouched without commit, got ", string(contents))
+	}
+
+	if f, err = NewAtomicFile(path); err != nil {
+		t.Fatal("NewAtomicFile failed -", err.Error())
+	}
+	defer f.Close()
+	f.Write([]byte("replaced"))
+	if err = f.Commit(); err != nil {
+		t.Fatal("Commit failed -", err.Error())
+	}
+
+	if contents, _ := ioutil.ReadFile(path); string(contents) != "replaced" {
+		t.Error("Expected replaced contents, got ", string(contents))
+	}
+
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0640 {
+		t.Error("Expected existing permissions to be kept, got ", info.Mode().Perm())
+	}
+
+	if entries, _ := ioutil.ReadDir(dir); len(entries) != 1 {
+		t.Error("Temporary files were left behind ", len(entries))
+	}
+}
+
+func TestAtomicFileNewFileMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ucp")
+	if err != nil {
+		t.Fatal("Temp dir creation failed -", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	// a new destination gets the permissions os.Create would give it
+	reference, err := os.Create(filepath.Join(dir, "reference"))
+	if err != nil {
+		t.Fatal("Create failed -", err.Error())
+	}
+	reference.Close()
+
+	var f *AtomicFile
+	if f, err = NewAtomicFile(filepath.Join(dir, "file")); err != nil {
+		t.Fatal("NewAtomicFile failed -", err.Error())
+	}
+	defer f.Close()
+
+	if !strings.HasPrefix(filepath.Base(f.Name()), ".file.ucp-") {
+		t.Error("Unexpected temporary file name ", f.Name())
+	}
+
+	if err = f.Commit(); err != nil {
+		t.Fatal("Commit failed -", err.Error())
+	}
+
+	want, _ := os.Stat(reference.Name())
+	if got, _ := os.Stat(filepath.Join(dir, "file")); got.Mode().Perm() != want.Mode().Perm() {
+		t.Error("Expected mode ", want.Mode().Perm(), " got ", got.Mode().Perm())
+	}
+}
@@ -4,20 +4,58 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"strings"
+	"time"
+
+	"github.com/murphybytes/ucp/wire"
 )
 
 const (
 	missingSourceMessage  = "-from is required in client mode."
 	missingTargetMessage  = "-to is required in client mode."
-	invalidLogLevel       = "-verbosity argument is not valid, must be one of INFO WARN ERROR"
+	invalidLogLevel       = "-verbosity argument is not valid, must be one of DEBUG INFO WARN ERROR"
+	invalidLogFormat      = "-log-format argument is not valid, must be one of text json"
 	missingPublicKeyPath  = "-public-key-path is required"
 	missingPrivateKeyPath = "-private-key-path is required"
+	syncOptionWithoutSync = "-delete, -dry-run and -checksum require -sync"
+	missingAdminSocket    = "-admin-socket is required for ucp admin"
+	syncWithStdio         = "-sync can't be used with - for stdin or stdout"
+	invalidCompression    = "-compress argument is not valid, must be one of none gzip auto"
+	invalidProgress       = "-progress argument is not valid, must be one of auto bar json none"
+	missingS3Credentials  = "-s3-bucket requires AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY to be set"
+	invalidRetries        = "-retries can't be negative"
+	missingSubcommand     = "A subcommand is required"
+	invalidArguments      = "Wrong number of arguments"
 
+	logDebug = "DEBUG"
 	logInfo  = "INFO"
 	logWarn  = "WARN"
 	logError = "ERROR"
+	// LogFormatText writes log lines as key=value pairs
+	LogFormatText = "text"
+	// LogFormatJSON writes log lines as JSON objects
+	LogFormatJSON = "json"
+	// LogOutputStderr writes log lines to stderr
+	LogOutputStderr = "stderr"
+	// LogOutputSyslog writes log lines to the system log
+	LogOutputSyslog = "syslog"
+	// CompressNone sends file data uncompressed
+	CompressNone = "none"
+	// CompressGzip compresses all file data with gzip
+	CompressGzip = "gzip"
+	// CompressAuto compresses file data with gzip unless it doesn't shrink
+	CompressAuto = "auto"
+	// ProgressAuto shows a progress bar if stdout is a terminal
+	ProgressAuto = "auto"
+	// ProgressBar always shows a progress bar
+	ProgressBar = "bar"
+	// ProgressJSON prints progress as a JSON object per line
+	ProgressJSON = "json"
+	// ProgressNone does not report progress
+	ProgressNone = "none"
+
 	// DefaultPort used to connect unless changed on command line
 	DefaultPort = 9191
 	// KeySize default RSA key size
@@ -38,58 +76,197 @@ type Flags struct {
 	Port int
 	// Interface the server uses
 	Host string
-	// Log level INFO, WARN, ERROR
+	// Log level DEBUG, INFO, WARN, ERROR
 	LogLevel string
+	// Log line format text or json
+	LogFormat string
+	// Where log lines are written, stderr, syslog or a file path
+	LogOutput string
 	// Path to public crypto key
 	PublicKeyPath string
 	//  Path to private key
 	PrivateKeyPath string
 	// Generate public private keys and exit
 	GenerateKeys bool
+	// Preserve mode bits and access and modification times
+	Preserve bool
+	// Preserve uid and gid, implies Preserve
+	PreserveOwner bool
+	// Preserve extended attributes, implies Preserve
+	PreserveXattrs bool
+	// Only send the parts of a file that differ from the destination
+	Delta bool
+	// Make directory To look like directory From
+	Sync bool
+	// Delete files in To that are not in From when synchronizing
+	Delete bool
+	// Print what synchronization would do without doing it
+	DryRun bool
+	// Compare files by checksum instead of modification time when synchronizing
+	Checksum bool
+	// Compression of file data none, gzip or auto
+	Compress string
+	// Bandwidth cap in bytes per second.  In server mode it is shared by all
+	// sessions
+	Limit Rate
+	// Server mode bandwidth cap shared by all sessions of a user
+	UserLimit Rate
+	// How progress is reported auto, bar, json or none
+	Progress string
+	// Server mode file that a JSON line is appended to for every session
+	AuditLog string
+	// Unix socket of the server's admin API
+	AdminSocket string
+	// Arguments of the admin subcommand, nil unless ucp admin was run
+	Admin []string
+	// Subcommand managing remote files, ls, stat, mkdir, rm, mv or shell,
+	// followed by its paths.  Nil unless one was run
+	Command []string
+	// ls lists the size, mode and modification time of each file
+	Long bool
+	// mkdir creates missing parent directories
+	Parents bool
+	// Server mode address of the HTTP listener serving /metrics
+	MetricsAddress string
+	// Server mode file with settings that are reread on SIGHUP
+	Config string
+	// How long a stopping server waits for transfers in progress
+	ShutdownTimeout time.Duration
+	// Maximum number of sessions the server runs at once, 0 for no limit
+	MaxSessions int
+	// Maximum number of sessions of one user, 0 for no limit
+	MaxUserSessions int
+	// How long a client has to authenticate
+	HandshakeTimeout time.Duration
+	// How long a session may wait for its peer before it is closed
+	IdleTimeout time.Duration
+	// Copy between two servers through the client instead of having the
+	// source server send the file to the destination server
+	Relay bool
+	// How many times a copy that failed because of its connection is
+	// attempted again
+	Retries int
+	// Server mode bucket files are stored in instead of the local file system
+	S3Bucket string
+	// URL of the S3 compatible service holding S3Bucket
+	S3Endpoint string
+	// Region of S3Bucket
+	S3Region string
+}
+
+// UsageError is returned by ParseFlags for command lines that are not valid
+// and requests for help.  Usage describes how the subcommand is used
+type UsageError struct {
+	// Err is flag.ErrHelp if help was asked for
+	Err   error
+	Usage string
 }
 
-// NewFlags returns a pointer to Flags which contains command line variables
-func NewFlags() (flags *Flags) {
-	var e error
+func (e *UsageError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *UsageError) Unwrap() error {
+	return e.Err
+}
+
+// ParseFlags parses the command line arguments args without the program name.
+// The first argument names a subcommand, command lines starting with an option
+// are the older form without one.  Every error is a UsageError
+func ParseFlags(args []string) (flags *Flags, e error) {
+	if len(args) == 0 {
+		return nil, &UsageError{errors.New(missingSubcommand), usage()}
+	}
+
+	if strings.HasPrefix(args[0], "-") {
+		return parseLegacyFlags(args)
+	}
+
+	if args[0] == "help" {
+		return nil, helpError(args[1:])
+	}
+
+	command, ok := subcommands[args[0]]
+	if !ok {
+		return nil, &UsageError{errors.New("Unknown subcommand " + args[0]), usage()}
+	}
+
 	flags = &Flags{}
-	flag.BoolVar(&flags.IsServer, "server", false, "Server mode. If set the application will listen for incoming client requests")
-	// client options
-	flag.StringVar(&flags.From, "from", "", "Client mode file to copy from.  [[user]@[host]:]filepath")
-	flag.StringVar(&flags.To, "to", "", "Client mode file to copy to. [[user]@[host]:]filepath")
-	flag.IntVar(&flags.Port, "port", DefaultPort, "Server Mode. The port that the ucp server listens on")
-	flag.StringVar(&flags.Host, "host", "127.0.0.1", "Server Mode. The host or interface the server listens on")
-	flag.StringVar(&flags.LogLevel, "verbosity", logWarn, "Log level. INFO|WARN|ERROR")
-	flag.StringVar(&flags.PrivateKeyPath, "private-key-path", getDefaultKeyPath("ucp.pem"), "Path to private key")
-	flag.StringVar(&flags.PublicKeyPath, "public-key-path", getDefaultKeyPath("key.pub"), "Path to public key")
-	flag.BoolVar(&flags.GenerateKeys, "generate-keys", false, "Generate key pair and exit")
-	flag.BoolVar(&flags.Help, "help", false, "Prints Usage")
-	flag.Parse()
+	fs := command.flagSet(args[0], flags)
+	if e = fs.Parse(args[1:]); e == nil {
+		if e = command.setArgs(flags, fs.Args()); e == nil {
+			e = validateFlags(flags)
+		}
+	}
 
-	if e = validateFlags(flags); e != nil {
-		fmt.Println("Missing or invalid command line arguments -", e.Error())
-		fmt.Println()
-		flag.PrintDefaults()
-		os.Exit(1)
+	if e != nil {
+		return nil, &UsageError{e, command.help(args[0], fs)}
 	}
 
-	if flags.Help {
-		flag.PrintDefaults()
-		os.Exit(0)
+	return
+}
+
+// parseLegacyFlags parses the command line of ucp before it had subcommands,
+// where -from and -to name the files to copy and -server and -generate-keys
+// select the other modes.  Options followed by a subcommand are passed to it
+func parseLegacyFlags(args []string) (flags *Flags, e error) {
+	flags = &Flags{}
+	fs := newFlagSet("ucp", flags)
+	addConnectFlags(fs, flags)
+	addCopyFlags(fs, flags)
+	addSyncFlags(fs, flags)
+	addServerFlags(fs, flags)
+	addPublicKeyFlag(fs, flags)
+	fs.BoolVar(&flags.IsServer, "server", false, "Server mode. If set the application will listen for incoming client requests")
+	fs.StringVar(&flags.From, "from", "", "Client mode file to copy from, - for stdin.  [[user]@[host]:]filepath")
+	fs.StringVar(&flags.To, "to", "", "Client mode file to copy to, - for stdout. [[user]@[host]:]filepath")
+	fs.BoolVar(&flags.Sync, "sync", false, "Client mode. Make the -to directory look like the -from directory, only copying files that differ")
+	fs.BoolVar(&flags.GenerateKeys, "generate-keys", false, "Generate key pair and exit")
+	fs.BoolVar(&flags.Help, "help", false, "Prints Usage")
+
+	legacyUsage := func() string {
+		var b strings.Builder
+		b.WriteString(usage())
+		b.WriteString("\nOptions without a subcommand:\n")
+		fs.SetOutput(&b)
+		fs.PrintDefaults()
+		fs.SetOutput(io.Discard)
+		return b.String()
 	}
 
-	if flags.GenerateKeys {
-		if e = ucpKeyGenerate(flags.PrivateKeyPath, flags.PublicKeyPath); e == nil {
-			fmt.Println("Key generation successful")
-			fmt.Println("Public key ->", flags.PublicKeyPath)
-			fmt.Println("Private key ->", flags.PrivateKeyPath)
-			os.Exit(0)
-		} else {
-			fmt.Println("Key generation failed -", e.Error())
-			os.Exit(1)
+	if e = fs.Parse(args); e != nil {
+		return nil, &UsageError{e, legacyUsage()}
+	}
+
+	if rest := fs.Args(); len(rest) > 0 {
+		if rest[0] == "help" {
+			return nil, helpError(rest[1:])
+		}
+
+		if _, ok := subcommands[rest[0]]; !ok {
+			return nil, &UsageError{errors.New("Unknown subcommand " + rest[0]), usage()}
 		}
+
+		// ucp -verbosity DEBUG admin sessions is ucp admin -verbosity DEBUG sessions
+		options := args[:len(args)-len(rest)]
+		subcommandArgs := append([]string{rest[0]}, options...)
+		return ParseFlags(append(subcommandArgs, rest[1:]...))
 	}
 
-	return flags
+	if flags.Help {
+		return nil, &UsageError{flag.ErrHelp, legacyUsage()}
+	}
+
+	if e = validateFlags(flags); e != nil {
+		return nil, &UsageError{e, legacyUsage()}
+	}
+
+	return
+}
+
+// DefaultPrivateKeyPath returns the private key used unless another is given
+func DefaultPrivateKeyPath() string {
+	return getDefaultKeyPath("ucp.pem")
 }
 
 func getDefaultKeyPath(keyname string) (path string) {
@@ -111,9 +288,64 @@ func validateClientFlags(flags *Flags) (e error) {
 		return
 	}
 
+	if e = validateCompress(flags); e != nil {
+		return
+	}
+
+	flags.Progress = strings.ToLower(flags.Progress)
+	if flags.Progress == "" {
+		flags.Progress = ProgressAuto
+	}
+
+	if !(flags.Progress == ProgressAuto || flags.Progress == ProgressBar || flags.Progress == ProgressJSON || flags.Progress == ProgressNone) {
+		e = errors.New(invalidProgress)
+		return
+	}
+
+	if (flags.Delete || flags.DryRun || flags.Checksum) && !flags.Sync {
+		e = errors.New(syncOptionWithoutSync)
+		return
+	}
+
+	if flags.Sync && (flags.From == "-" || flags.To == "-") {
+		e = errors.New(syncWithStdio)
+		return
+	}
+
+	if flags.Retries < 0 {
+		e = errors.New(invalidRetries)
+		return
+	}
+
+	// modification times have to be preserved for later synchronizations to
+	// find unchanged files
+	if flags.PreserveOwner || flags.PreserveXattrs || flags.Sync {
+		flags.Preserve = true
+	}
+
+	// stdin has no attributes to preserve
+	if flags.From == "-" {
+		flags.Preserve = false
+		flags.PreserveOwner = false
+		flags.PreserveXattrs = false
+	}
+
 	return
 }
 
+func validateCompress(flags *Flags) error {
+	flags.Compress = strings.ToLower(flags.Compress)
+	if flags.Compress == "" {
+		flags.Compress = CompressNone
+	}
+
+	if !(flags.Compress == CompressNone || flags.Compress == CompressGzip || flags.Compress == CompressAuto) {
+		return errors.New(invalidCompression)
+	}
+
+	return nil
+}
+
 func validateKeygenFlags(flags *Flags) error {
 	if flags.PrivateKeyPath == "" {
 		return errors.New(missingPrivateKeyPath)
@@ -128,11 +360,17 @@ func validateKeygenFlags(flags *Flags) error {
 
 func validateFlags(flags *Flags) (e error) {
 	flags.LogLevel = strings.ToUpper(flags.LogLevel)
-	if !(flags.LogLevel == logInfo || flags.LogLevel == logWarn || flags.LogLevel == logError) {
+	if !validLogLevel(flags.LogLevel) {
 		e = errors.New(invalidLogLevel)
 		return
 	}
 
+	flags.LogFormat = strings.ToLower(flags.LogFormat)
+	if !(flags.LogFormat == "" || flags.LogFormat == LogFormatText || flags.LogFormat == LogFormatJSON) {
+		e = errors.New(invalidLogFormat)
+		return
+	}
+
 	if flags.GenerateKeys {
 		return validateKeygenFlags(flags)
 	}
@@ -141,9 +379,73 @@ func validateFlags(flags *Flags) (e error) {
 		return validateServerFlags(flags)
 	}
 
+	if flags.Admin != nil {
+		return validateAdminFlags(flags)
+	}
+
+	if flags.Command != nil {
+		return validateCommandFlags(flags)
+	}
+
 	return validateClientFlags(flags)
 }
 
+func validateAdminFlags(flags *Flags) error {
+	if flags.AdminSocket == "" {
+		return errors.New(missingAdminSocket)
+	}
+
+	return nil
+}
+
+// validateCommandFlags checks the paths of the subcommand in flags.Command
+func validateCommandFlags(flags *Flags) (e error) {
+	name, paths := flags.Command[0], flags.Command[1:]
+	if count, ok := commandPaths[name]; len(paths) == 0 || (ok && len(paths) != count) {
+		return errors.New(invalidArguments)
+	}
+
+	return validateCompress(flags)
+}
+
 func validateServerFlags(flags *Flags) (e error) {
+	if flags.S3Bucket != "" && (os.Getenv("AWS_ACCESS_KEY_ID") == "" || os.Getenv("AWS_SECRET_ACCESS_KEY") == "") {
+		return errors.New(missingS3Credentials)
+	}
+
+	return
+}
+
+func validLogLevel(level string) bool {
+	_, ok := logLevels[level]
+	return ok
+}
+
+// TransferOptions returns the options of the transfers flags request
+func TransferOptions(flags *Flags) (options wire.TransferOption) {
+	if flags.Preserve {
+		options |= wire.PreserveMetadata
+	}
+
+	if flags.PreserveOwner {
+		options |= wire.PreserveOwner
+	}
+
+	if flags.PreserveXattrs {
+		options |= wire.PreserveXattrs
+	}
+
+	if flags.Delta {
+		options |= wire.DeltaTransfer
+	}
+
+	if flags.Checksum {
+		options |= wire.ListChecksums
+	}
+
+	if flags.Sync {
+		options |= wire.CreateDirectories
+	}
+
 	return
 }
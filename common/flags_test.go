@@ -1,6 +1,11 @@
 package common
 
-import "testing"
+import (
+	"errors"
+	"flag"
+	"strings"
+	"testing"
+)
 
 func TestClientValidation(t *testing.T) {
 	flags := Flags{}
@@ -21,6 +26,103 @@ func TestClientValidation(t *testing.T) {
 	}
 }
 
+func TestSyncValidation(t *testing.T) {
+	flags := Flags{
+		From:   "/src",
+		To:     "foo@bar:/dst",
+		Delete: true,
+	}
+	err := validateClientFlags(&flags)
+	if err == nil || err.Error() != syncOptionWithoutSync {
+		t.Error("Expected ", syncOptionWithoutSync, " got ", err)
+	}
+
+	flags.Sync = true
+	if err = validateClientFlags(&flags); err != nil {
+		t.Error("Unexpected error ", err)
+	}
+
+	if !flags.Preserve {
+		t.Error("Expected -sync to imply -p")
+	}
+}
+
+func TestCompressValidation(t *testing.T) {
+	flags := Flags{
+		From:     "/src",
+		To:       "foo@bar:/dst",
+		Compress: "zip",
+	}
+	err := validateClientFlags(&flags)
+	if err == nil || err.Error() != invalidCompression {
+		t.Error("Expected ", invalidCompression, " got ", err)
+	}
+
+	flags.Compress = "GZIP"
+	if err = validateClientFlags(&flags); err != nil {
+		t.Error("Unexpected error ", err)
+	}
+
+	if flags.Compress != CompressGzip {
+		t.Error("Expected compression to be normalized, got ", flags.Compress)
+	}
+}
+
+func TestSyncWithStdio(t *testing.T) {
+	flags := Flags{
+		From: "-",
+		To:   "foo@bar:/dst",
+		Sync: true,
+	}
+	err := validateClientFlags(&flags)
+	if err == nil || err.Error() != syncWithStdio {
+		t.Error("Expected ", syncWithStdio, " got ", err)
+	}
+
+	flags.Sync = false
+	flags.PreserveOwner = true
+	if err = validateClientFlags(&flags); err != nil {
+		t.Error("Unexpected error ", err)
+	}
+
+	if flags.Preserve || flags.PreserveOwner {
+		t.Error("Nothing can be preserved from stdin")
+	}
+}
+
+func TestProgressValidation(t *testing.T) {
+	flags := Flags{
+		From:     "/src",
+		To:       "foo@bar:/dst",
+		Progress: "fancy",
+	}
+	err := validateClientFlags(&flags)
+	if err == nil || err.Error() != invalidProgress {
+		t.Error("Expected ", invalidProgress, " got ", err)
+	}
+
+	flags.Progress = ""
+	if err = validateClientFlags(&flags); err != nil {
+		t.Error("Unexpected error ", err)
+	}
+
+	if flags.Progress != ProgressAuto {
+		t.Error("Expected progress to default to auto, got ", flags.Progress)
+	}
+}
+
+func TestRetriesValidation(t *testing.T) {
+	flags := Flags{
+		From:    "/src",
+		To:      "foo@bar:/dst",
+		Retries: -1,
+	}
+	err := validateClientFlags(&flags)
+	if err == nil || err.Error() != invalidRetries {
+		t.Error("Expected ", invalidRetries, " got ", err)
+	}
+}
+
 func TestLoggingArg(t *testing.T) {
 	flags := &Flags{
 		IsServer: true,
@@ -37,3 +139,113 @@ func TestLoggingArg(t *testing.T) {
 		t.Error("We don't expect error when log level is valid")
 	}
 }
+
+func TestS3Validation(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	flags := Flags{IsServer: true, S3Bucket: "bucket"}
+	if err := validateServerFlags(&flags); err == nil || err.Error() != missingS3Credentials {
+		t.Error("Expected ", missingS3Credentials, " got ", err)
+	}
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "access")
+	if err := validateServerFlags(&flags); err != nil {
+		t.Error("Unexpected error ", err)
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	flags, err := ParseFlags([]string{"cp", "-p", "-compress", "GZIP", "/src", "foo@bar:/dst"})
+	if err != nil {
+		t.Fatal("Unexpected error ", err)
+	}
+
+	if flags.From != "/src" || flags.To != "foo@bar:/dst" || !flags.Preserve || flags.Compress != CompressGzip || flags.Retries != 3 {
+		t.Error("Unexpected flags ", flags)
+	}
+
+	if flags, err = ParseFlags([]string{"mkdir", "-p", "foo@bar:/a/b", "foo@bar:/c"}); err != nil {
+		t.Fatal("Unexpected error ", err)
+	}
+
+	if !flags.Parents || len(flags.Command) != 3 || flags.Command[1] != "foo@bar:/a/b" {
+		t.Error("Expected -p to create parents and the paths to be kept, got ", flags.Command)
+	}
+
+	if flags, err = ParseFlags([]string{"serve", "-port", "9000", "-user-limit", "1M"}); err != nil || !flags.IsServer || flags.Port != 9000 || flags.UserLimit != 1<<20 {
+		t.Error("Unexpected server flags ", flags, " ", err)
+	}
+
+	if flags, err = ParseFlags([]string{"admin"}); err != nil || flags.Admin == nil {
+		t.Error("Expected ucp admin to select the admin mode, got ", err)
+	}
+}
+
+func TestParseLegacyFlags(t *testing.T) {
+	flags, err := ParseFlags([]string{"-from", "/src", "-to", "foo@bar:/dst", "-sync", "-delete"})
+	if err != nil {
+		t.Fatal("Unexpected error ", err)
+	}
+
+	if flags.From != "/src" || flags.To != "foo@bar:/dst" || !flags.Sync || !flags.Delete {
+		t.Error("Unexpected flags ", flags)
+	}
+
+	// options before a subcommand are passed to it
+	if flags, err = ParseFlags([]string{"-verbosity", "debug", "admin", "cancel", "42"}); err != nil {
+		t.Fatal("Unexpected error ", err)
+	}
+
+	if flags.LogLevel != logDebug || len(flags.Admin) != 2 || flags.Admin[1] != "42" {
+		t.Error("Unexpected admin flags ", flags.LogLevel, " ", flags.Admin)
+	}
+
+	if _, err = ParseFlags([]string{"-from", "/src"}); err == nil || err.Error() != missingTargetMessage {
+		t.Error("Expected ", missingTargetMessage, " got ", err)
+	}
+}
+
+func TestParseFlagsErrors(t *testing.T) {
+	invalid := [][]string{
+		{},
+		{"copy", "/src", "/dst"},
+		{"cp", "/src"},
+		{"cp", "-delete", "/src", "/dst"},
+		{"serve", "extra"},
+		{"ls"},
+		{"mv", "foo@bar:/a"},
+		{"shell", "foo@bar", "foo@baz"},
+		{"-port", "x"},
+	}
+
+	for _, args := range invalid {
+		_, err := ParseFlags(args)
+		var usage *UsageError
+		if !errors.As(err, &usage) || usage.Usage == "" || errors.Is(err, flag.ErrHelp) {
+			t.Error("Expected a usage error for ", args, " got ", err)
+		}
+
+		if ExitCode(err) != 2 {
+			t.Error("Expected exit code 2 for ", args, " got ", ExitCode(err))
+		}
+	}
+
+	for _, args := range [][]string{{"help"}, {"help", "cp"}, {"cp", "-h"}, {"-help"}} {
+		_, err := ParseFlags(args)
+		var usage *UsageError
+		if !errors.Is(err, flag.ErrHelp) || !errors.As(err, &usage) || !strings.HasPrefix(usage.Usage, "usage: ucp") {
+			t.Error("Expected help for ", args, " got ", err)
+		}
+	}
+
+	_, err := ParseFlags([]string{"help", "cp"})
+	if usage := err.(*UsageError).Usage; !strings.Contains(usage, "-retries") || strings.Contains(usage, "-port") {
+		t.Error("Expected the options of cp, got\n", usage)
+	}
+
+	// the server reads its configuration file, failing to isn't a usage error
+	if _, err = ParseFlags([]string{"serve", "-config", "/nonexistent/ucp.conf"}); err != nil {
+		t.Error("Expected the configuration file not to be read with the command line, got ", err)
+	}
+}
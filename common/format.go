@@ -0,0 +1,19 @@
+package common
+
+import "fmt"
+
+// FormatBytes returns n with a binary unit, e.g. 1.5 MB
+func FormatBytes(n float64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	unit := 0
+	for n >= 1024 && unit < len(units)-1 {
+		n /= 1024
+		unit++
+	}
+
+	if unit == 0 {
+		return fmt.Sprintf("%.0f %s", n, units[unit])
+	}
+
+	return fmt.Sprintf("%.1f %s", n, units[unit])
+}
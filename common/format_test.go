@@ -0,0 +1,18 @@
+package common
+
+import "testing"
+
+func TestFormatBytes(t *testing.T) {
+	expected := map[float64]string{
+		0:           "0 B",
+		1023:        "1023 B",
+		1536:        "1.5 KB",
+		5 * 1 << 30: "5.0 GB",
+	}
+
+	for n, formatted := range expected {
+		if s := FormatBytes(n); s != formatted {
+			t.Error("Expected ", formatted, " for ", n, " got ", s)
+		}
+	}
+}
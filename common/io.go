@@ -1,6 +1,6 @@
 package common
 
-import "net"
+import "crypto/rsa"
 
 // Reader abstracts reading a file which
 // can be local or remote
@@ -16,7 +16,7 @@ type Writer interface {
 	Close()
 }
 
-// Authenticator handles authenticating operation
+// Authenticator decides whether the client holding key may connect as user
 type Authenticator interface {
-	Authenticate(conn net.Conn, user string) (bool, error)
+	Authenticate(user string, key *rsa.PublicKey) (bool, error)
 }
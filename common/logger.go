@@ -1,70 +1,160 @@
 package common
 
 import (
+	"context"
 	"errors"
-	"log"
+	"fmt"
+	"io"
+	"log/slog"
 	"os"
+	"sync"
 )
 
-// Logger wraps Go logger but allows variable verbosity
+// Logger is a leveled structured logger.  Messages are formatted like
+// fmt.Sprint, fields added with With are attached to every line
 type Logger interface {
+	LogDebug(v ...interface{})
 	LogInfo(v ...interface{})
 	LogWarn(v ...interface{})
 	LogError(v ...interface{})
 	LogFatal(v ...interface{})
+	// With returns a logger that adds the key value pairs in args to each
+	// line
+	With(args ...interface{}) Logger
 }
 
 type logger struct {
-	verbosity int
-	logFunc   func(v ...interface{})
+	slogger *slog.Logger
 }
 
-// NewLogger creates a logger
+var logLevels = map[string]slog.Level{
+	logDebug: slog.LevelDebug,
+	logInfo:  slog.LevelInfo,
+	logWarn:  slog.LevelWarn,
+	logError: slog.LevelError,
+}
+
+// NewLogger creates a logger that writes to the output named by -log-output
+// in the format named by -log-format
 func NewLogger(f *Flags) (l Logger, e error) {
-	return newLogger(f, log.Print)
+	var w io.Writer
+	if w, e = openLogOutput(f.LogOutput); e != nil {
+		return
+	}
+
+	return newLogger(f, w)
+}
+
+// DiscardLogger returns a Logger that drops every line
+func DiscardLogger() Logger {
+	l, _ := newLogger(&Flags{LogLevel: logError}, io.Discard)
+	return l
 }
 
-// NewLogger creates a logger
-func newLogger(f *Flags, logFunc func(v ...interface{})) (l Logger, e error) {
-	levels := map[string]int{
-		logInfo:  3,
-		logWarn:  2,
-		logError: 1,
+func newLogger(f *Flags, w io.Writer) (l Logger, e error) {
+	level, ok := logLevels[f.LogLevel]
+	if !ok {
+		return nil, errors.New("Invalid log level")
 	}
 
-	if level, ok := levels[f.LogLevel]; ok {
-		l = &logger{
-			verbosity: level,
-			logFunc:   logFunc,
-		}
-	} else {
-		e = errors.New("Invalid log level")
+	options := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	switch f.LogFormat {
+	case LogFormatJSON:
+		handler = slog.NewJSONHandler(w, options)
+	case LogFormatText, "":
+		handler = slog.NewTextHandler(w, options)
+	default:
+		return nil, errors.New("Invalid log format")
 	}
 
-	return
+	if lw, ok := w.(leveledWriter); ok {
+		handler = &leveledHandler{Handler: handler, w: lw}
+	}
+
+	return &logger{slogger: slog.New(handler)}, nil
 }
 
-func (l *logger) LogInfo(v ...interface{}) {
-	if l.verbosity >= 3 {
-		l.logFunc(v...)
-	}
+// leveledWriter is a log output that keeps the level of each line, such as
+// syslog.  writeAt calls write, which writes one line of level
+type leveledWriter interface {
+	io.Writer
+	writeAt(level slog.Level, write func() error) error
 }
 
-func (l *logger) LogWarn(v ...interface{}) {
-	if l.verbosity >= 2 {
-		l.logFunc(v...)
+// leveledHandler passes the level of each record to the leveledWriter the
+// handler it wraps writes to
+type leveledHandler struct {
+	slog.Handler
+	w leveledWriter
+}
+
+func (h *leveledHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.w.writeAt(r.Level, func() error {
+		return h.Handler.Handle(ctx, r)
+	})
+}
+
+func (h *leveledHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &leveledHandler{Handler: h.Handler.WithAttrs(attrs), w: h.w}
+}
+
+func (h *leveledHandler) WithGroup(name string) slog.Handler {
+	return &leveledHandler{Handler: h.Handler.WithGroup(name), w: h.w}
+}
+
+var (
+	logFilesMutex sync.Mutex
+	// log files stay open for loggers created on reload
+	logFiles = make(map[string]*os.File)
+)
+
+func openLogOutput(output string) (w io.Writer, e error) {
+	switch output {
+	case LogOutputStderr, "":
+		return os.Stderr, nil
+	case LogOutputSyslog:
+		return openSyslog()
+	}
+
+	logFilesMutex.Lock()
+	defer logFilesMutex.Unlock()
+
+	if f, ok := logFiles[output]; ok {
+		return f, nil
 	}
 
+	var f *os.File
+	if f, e = os.OpenFile(output, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644); e != nil {
+		return
+	}
+
+	logFiles[output] = f
+	return f, nil
+}
+
+func (l *logger) With(args ...interface{}) Logger {
+	return &logger{slogger: l.slogger.With(args...)}
+}
+
+func (l *logger) LogDebug(v ...interface{}) {
+	l.slogger.Debug(fmt.Sprint(v...))
+}
+
+func (l *logger) LogInfo(v ...interface{}) {
+	l.slogger.Info(fmt.Sprint(v...))
+}
+
+func (l *logger) LogWarn(v ...interface{}) {
+	l.slogger.Warn(fmt.Sprint(v...))
 }
 
 func (l *logger) LogError(v ...interface{}) {
-	if l.verbosity >= 1 {
-		l.logFunc(v...)
-	}
+	l.slogger.Error(fmt.Sprint(v...))
 }
 
 func (l *logger) LogFatal(v ...interface{}) {
-	l.logFunc(v...)
+	l.slogger.Error(fmt.Sprint(v...))
 	os.Exit(1)
-
 }
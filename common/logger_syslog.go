@@ -0,0 +1,82 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package common
+
+import (
+	"io"
+	"log/slog"
+	"log/syslog"
+	"sync"
+)
+
+// syslogWriter sends each log line to syslog with the priority of its level
+type syslogWriter struct {
+	mutex  sync.Mutex
+	writer *syslog.Writer
+	level  slog.Level
+}
+
+type syslogKey struct {
+	facility syslog.Priority
+	tag      string
+}
+
+var (
+	syslogMutex sync.Mutex
+	// like log files syslog connections are shared by every logger
+	syslogWriters = make(map[syslogKey]*syslogWriter)
+)
+
+func openSyslog() (io.Writer, error) {
+	return openSyslogWriter(syslog.LOG_DAEMON, "ucp")
+}
+
+// openSyslogWriter returns the writer of facility and tag, connecting to
+// syslog the first time
+func openSyslogWriter(facility syslog.Priority, tag string) (*syslogWriter, error) {
+	syslogMutex.Lock()
+	defer syslogMutex.Unlock()
+
+	key := syslogKey{facility, tag}
+	if w, ok := syslogWriters[key]; ok {
+		return w, nil
+	}
+
+	writer, e := syslog.New(syslog.LOG_INFO|facility, tag)
+	if e != nil {
+		return nil, e
+	}
+
+	w := &syslogWriter{writer: writer, level: slog.LevelInfo}
+	syslogWriters[key] = w
+	return w, nil
+}
+
+func (w *syslogWriter) writeAt(level slog.Level, write func() error) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.level = level
+	return write()
+}
+
+func (w *syslogWriter) Write(p []byte) (n int, e error) {
+	line := string(p)
+	switch {
+	case w.level >= slog.LevelError:
+		e = w.writer.Err(line)
+	case w.level >= slog.LevelWarn:
+		e = w.writer.Warning(line)
+	case w.level >= slog.LevelInfo:
+		e = w.writer.Info(line)
+	default:
+		e = w.writer.Debug(line)
+	}
+
+	if e != nil {
+		return 0, e
+	}
+
+	return len(p), nil
+}
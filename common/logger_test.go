@@ -1,21 +1,30 @@
 package common
 
-import "testing"
-
+import (
+	"encoding/json"
+	"log/slog"
+	"reflect"
+	"testing"
+)
+
+// mockLogger records the message and fields of the last JSON log line
 type mockLogger struct {
-	msg string
+	msg    string
+	fields map[string]interface{}
 }
 
-func (m *mockLogger) print(vals ...interface{}) {
-	m.msg = ""
-	for _, val := range vals {
-		m.msg += val.(string)
+func (m *mockLogger) Write(b []byte) (int, error) {
+	m.fields = make(map[string]interface{})
+	if err := json.Unmarshal(b, &m.fields); err != nil {
+		return 0, err
 	}
+	m.msg, _ = m.fields["msg"].(string)
+	return len(b), nil
 }
 
 func TestErrorLogger(t *testing.T) {
 	m := &mockLogger{}
-	l, _ := newLogger(&Flags{LogLevel: logError}, m.print)
+	l, _ := newLogger(&Flags{LogLevel: logError, LogFormat: LogFormatJSON}, m)
 	l.LogInfo("info")
 	if m.msg != "" {
 		t.Error("Info message shouldn't be logged")
@@ -35,7 +44,7 @@ func TestErrorLogger(t *testing.T) {
 
 func TestWarnLogger(t *testing.T) {
 	m := &mockLogger{}
-	l, _ := newLogger(&Flags{LogLevel: logWarn}, m.print)
+	l, _ := newLogger(&Flags{LogLevel: logWarn, LogFormat: LogFormatJSON}, m)
 	l.LogInfo("info")
 	if m.msg != "" {
 		t.Error("Info message shouldn't be logged")
@@ -55,7 +64,7 @@ func TestWarnLogger(t *testing.T) {
 
 func TestInfoLogger(t *testing.T) {
 	m := &mockLogger{}
-	l, _ := newLogger(&Flags{LogLevel: logInfo}, m.print)
+	l, _ := newLogger(&Flags{LogLevel: logInfo, LogFormat: LogFormatJSON}, m)
 	l.LogInfo("info")
 	if m.msg != "info" {
 		t.Error("Info message should be logged")
@@ -75,9 +84,90 @@ func TestInfoLogger(t *testing.T) {
 
 func TestLoggerFailed(t *testing.T) {
 	m := &mockLogger{}
-	_, e := newLogger(&Flags{LogLevel: "foo"}, m.print)
+	_, e := newLogger(&Flags{LogLevel: "foo"}, m)
 	if e == nil {
 		t.Error("There is not an error foo")
 	}
 
+	_, e = newLogger(&Flags{LogLevel: logInfo, LogFormat: "xml"}, m)
+	if e == nil {
+		t.Error("There is not a format xml")
+	}
+
+}
+
+func TestDebugLogger(t *testing.T) {
+	m := &mockLogger{}
+	l, _ := newLogger(&Flags{LogLevel: logInfo, LogFormat: LogFormatJSON}, m)
+	l.LogDebug("debug")
+	if m.msg != "" {
+		t.Error("Debug message shouldn't be logged")
+	}
+
+	l, _ = newLogger(&Flags{LogLevel: logDebug, LogFormat: LogFormatJSON}, m)
+	l.LogDebug("debug ", 42)
+	if m.msg != "debug 42" {
+		t.Error("Debug message should be logged, got ", m.msg)
+	}
+}
+
+func TestLoggerWith(t *testing.T) {
+	m := &mockLogger{}
+	l, _ := newLogger(&Flags{LogLevel: logInfo, LogFormat: LogFormatJSON}, m)
+	l.With("session", 7).With("user", "alice").LogInfo("hello")
+
+	if m.msg != "hello" {
+		t.Error("Expected hello got ", m.msg)
+	}
+
+	if m.fields["session"] != float64(7) || m.fields["user"] != "alice" {
+		t.Error("Expected session and user fields, got ", m.fields)
+	}
+
+	l.LogInfo("plain")
+	if _, ok := m.fields["session"]; ok {
+		t.Error("With should not change the original logger")
+	}
+}
+
+// mockLeveledWriter records the level of each line it is given
+type mockLeveledWriter struct {
+	level  slog.Level
+	levels []slog.Level
+}
+
+func (m *mockLeveledWriter) writeAt(level slog.Level, write func() error) error {
+	m.level = level
+	return write()
+}
+
+func (m *mockLeveledWriter) Write(b []byte) (int, error) {
+	m.levels = append(m.levels, m.level)
+	return len(b), nil
+}
+
+func TestLeveledWriter(t *testing.T) {
+	m := &mockLeveledWriter{}
+	l, _ := newLogger(&Flags{LogLevel: logDebug}, m)
+	l.LogDebug("debug")
+	l.LogInfo("info")
+	l = l.With("session", 1)
+	l.LogWarn("warn")
+	l.LogError("error")
+
+	expected := []slog.Level{slog.LevelDebug, slog.LevelInfo, slog.LevelWarn, slog.LevelError}
+	if !reflect.DeepEqual(m.levels, expected) {
+		t.Error("Expected lines at ", expected, " got ", m.levels)
+	}
+}
+
+func TestSyslogShared(t *testing.T) {
+	first, err := openSyslog()
+	if err != nil {
+		t.Skip("syslog is not available - ", err.Error())
+	}
+
+	if second, _ := openSyslog(); second != first {
+		t.Error("Expected loggers to share the syslog connection")
+	}
 }
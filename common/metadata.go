@@ -0,0 +1,97 @@
+package common
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/murphybytes/ucp/wire"
+)
+
+// PreservedModeBits are the mode bits file metadata and listings carry
+const PreservedModeBits = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// GetFileMetadata returns the attributes of the file at path.  Extended
+// attributes are only collected if options includes PreserveXattrs
+func GetFileMetadata(path string, options wire.TransferOption) (md *wire.FileMetadata, e error) {
+	var info os.FileInfo
+	if info, e = os.Stat(path); e != nil {
+		return
+	}
+
+	md = &wire.FileMetadata{
+		Mode:       uint32(info.Mode() & PreservedModeBits),
+		ModTime:    info.ModTime().UnixNano(),
+		AccessTime: info.ModTime().UnixNano(),
+		UID:        -1,
+		GID:        -1,
+		Status:     wire.OK,
+		StatusText: "OK",
+	}
+
+	getSystemMetadata(info, md)
+
+	if options.Has(wire.PreserveXattrs) {
+		if md.Xattrs, e = getXattrs(path); e != nil {
+			return nil, e
+		}
+	}
+
+	return
+}
+
+// UserFileMetadata returns the part of md a file written for userName may
+// get.  Setuid and setgid are cleared, the owner is the user and attributes in
+// the security and trusted namespaces, such as file capabilities, are dropped.
+// Servers apply it to what clients send since they usually run as root
+func UserFileMetadata(md *wire.FileMetadata, userName string) *wire.FileMetadata {
+	restricted := *md
+	restricted.Mode = uint32(os.FileMode(md.Mode) & PreservedModeBits &^ (os.ModeSetuid | os.ModeSetgid))
+	restricted.UID, restricted.GID = -1, -1
+	if usr, e := user.Lookup(userName); e == nil {
+		uid, uidErr := strconv.Atoi(usr.Uid)
+		gid, gidErr := strconv.Atoi(usr.Gid)
+		if uidErr == nil && gidErr == nil {
+			restricted.UID, restricted.GID = uid, gid
+		}
+	}
+
+	restricted.Xattrs = nil
+	for name, value := range md.Xattrs {
+		if !strings.HasPrefix(name, "security.") && !strings.HasPrefix(name, "trusted.") {
+			if restricted.Xattrs == nil {
+				restricted.Xattrs = make(map[string][]byte)
+			}
+			restricted.Xattrs[name] = value
+		}
+	}
+
+	return &restricted
+}
+
+// ApplyFileMetadata sets the attributes in md on the file at path.  Ownership
+// is only changed if options includes PreserveOwner and the process is
+// running as root, extended attributes only if options includes PreserveXattrs
+func ApplyFileMetadata(path string, md *wire.FileMetadata, options wire.TransferOption) (e error) {
+	// chown clears setuid and setgid bits and the security.capability
+	// attribute, so it has to happen before xattrs are set and before chmod
+	if options.Has(wire.PreserveOwner) && os.Geteuid() == 0 && md.UID >= 0 {
+		if e = os.Lchown(path, md.UID, md.GID); e != nil {
+			return
+		}
+	}
+
+	if options.Has(wire.PreserveXattrs) {
+		if e = setXattrs(path, md.Xattrs); e != nil {
+			return
+		}
+	}
+
+	if e = os.Chmod(path, os.FileMode(md.Mode)&PreservedModeBits); e != nil {
+		return
+	}
+
+	return os.Chtimes(path, time.Unix(0, md.AccessTime), time.Unix(0, md.ModTime))
+}
@@ -0,0 +1,85 @@
+package common
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/murphybytes/ucp/wire"
+)
+
+func getSystemMetadata(info os.FileInfo, md *wire.FileMetadata) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		md.AccessTime = stat.Atim.Nano()
+		md.UID = int(stat.Uid)
+		md.GID = int(stat.Gid)
+	}
+}
+
+func getXattrs(path string) (xattrs map[string][]byte, e error) {
+	var size int
+	if size, e = syscall.Listxattr(path, nil); e != nil || size == 0 {
+		if e == syscall.ENOTSUP {
+			e = nil
+		}
+		return
+	}
+
+	names := make([]byte, size)
+	if size, e = syscall.Listxattr(path, names); e != nil {
+		return
+	}
+
+	xattrs = make(map[string][]byte)
+	for _, name := range bytes.Split(names[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+
+		if size, e = syscall.Getxattr(path, string(name), nil); e != nil {
+			return
+		}
+
+		value := make([]byte, size)
+		if size, e = syscall.Getxattr(path, string(name), value); e != nil {
+			return
+		}
+
+		xattrs[string(name)] = value[:size]
+	}
+
+	return
+}
+
+func setXattrs(path string, xattrs map[string][]byte) (e error) {
+	for name, value := range xattrs {
+		if e = syscall.Setxattr(path, name, value, 0); e != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// umask returns the permission bits new files of the process don't get.  It
+// is read from /proc since setting the umask to read it would affect files
+// other goroutines create in the meantime
+func umask() os.FileMode {
+	status, e := ioutil.ReadFile("/proc/self/status")
+	if e != nil {
+		return 022
+	}
+
+	for _, line := range strings.Split(string(status), "\n") {
+		if value := strings.TrimPrefix(line, "Umask:"); value != line {
+			if mask, err := strconv.ParseUint(strings.TrimSpace(value), 8, 32); err == nil {
+				return os.FileMode(mask)
+			}
+		}
+	}
+
+	return 022
+}
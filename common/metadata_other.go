@@ -0,0 +1,30 @@
+//go:build !linux
+// +build !linux
+
+package common
+
+import (
+	"errors"
+	"os"
+
+	"github.com/murphybytes/ucp/wire"
+)
+
+func getSystemMetadata(info os.FileInfo, md *wire.FileMetadata) {
+}
+
+func getXattrs(path string) (xattrs map[string][]byte, e error) {
+	return
+}
+
+func setXattrs(path string, xattrs map[string][]byte) (e error) {
+	if len(xattrs) > 0 {
+		e = errors.New("Extended attributes are not supported on this platform")
+	}
+	return
+}
+
+// umask returns the permission bits new files usually don't get
+func umask() os.FileMode {
+	return 022
+}
@@ -0,0 +1,55 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/murphybytes/ucp/wire"
+)
+
+func TestFileMetadataRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ucp")
+	if err != nil {
+		t.Fatal("Temp dir creation failed -", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "source")
+	target := filepath.Join(dir, "target")
+	if err = ioutil.WriteFile(source, []byte("source"), 0755); err != nil {
+		t.Fatal("Write failed -", err.Error())
+	}
+	if err = ioutil.WriteFile(target, []byte("target"), 0600); err != nil {
+		t.Fatal("Write failed -", err.Error())
+	}
+
+	modTime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	if err = os.Chtimes(source, modTime, modTime); err != nil {
+		t.Fatal("Chtimes failed -", err.Error())
+	}
+
+	var md *wire.FileMetadata
+	if md, err = GetFileMetadata(source, wire.PreserveMetadata); err != nil {
+		t.Fatal("GetFileMetadata failed -", err.Error())
+	}
+
+	if err = ApplyFileMetadata(target, md, wire.PreserveMetadata); err != nil {
+		t.Fatal("ApplyFileMetadata failed -", err.Error())
+	}
+
+	var info os.FileInfo
+	if info, err = os.Stat(target); err != nil {
+		t.Fatal("Stat failed -", err.Error())
+	}
+
+	if info.Mode().Perm() != 0755 {
+		t.Error("Expected mode 0755 got ", info.Mode().Perm())
+	}
+
+	if !info.ModTime().Equal(modTime) {
+		t.Error("Expected mtime ", modTime, " got ", info.ModTime())
+	}
+}
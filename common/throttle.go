@@ -0,0 +1,177 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate is a transfer rate in bytes per second.  It is set from strings such as
+// 200M with an optional K, M or G suffix so it can be used as a flag value
+type Rate int64
+
+var rateSuffixes = map[byte]Rate{
+	'K': 1 << 10,
+	'M': 1 << 20,
+	'G': 1 << 30,
+}
+
+// ParseRate parses a rate such as 512K or 200M
+func ParseRate(rate string) (r Rate, e error) {
+	s := strings.ToUpper(strings.TrimSpace(rate))
+	multiplier := Rate(1)
+	if len(s) > 0 {
+		if m, ok := rateSuffixes[s[len(s)-1]]; ok {
+			multiplier = m
+			s = s[:len(s)-1]
+		}
+	}
+
+	var value int64
+	if value, e = strconv.ParseInt(s, 10, 64); e != nil || value < 0 {
+		return 0, errors.New("Invalid rate '" + rate + "', expected a number of bytes with optional K, M or G suffix")
+	}
+
+	return Rate(value) * multiplier, nil
+}
+
+// Set implements flag.Value
+func (r *Rate) Set(s string) (e error) {
+	*r, e = ParseRate(s)
+	return
+}
+
+func (r *Rate) String() string {
+	for _, suffix := range []byte{'G', 'M', 'K'} {
+		if m := rateSuffixes[suffix]; *r >= m && *r%m == 0 {
+			return fmt.Sprintf("%d%c", *r/m, suffix)
+		}
+	}
+	return strconv.FormatInt(int64(*r), 10)
+}
+
+// Throttle is a token bucket limiting the rate of a transfer.  It may be
+// shared between transfers, which then share the rate.  A nil Throttle, or one
+// without a positive rate, does not limit anything
+type Throttle struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewThrottle returns a Throttle for rate, or nil if rate is not positive
+func NewThrottle(rate Rate) *Throttle {
+	if rate <= 0 {
+		return nil
+	}
+
+	t := &Throttle{}
+	t.SetRate(rate)
+
+	return t
+}
+
+// SetRate changes the rate of t for the transfers sharing it, a rate that is
+// not positive lifts the limit
+func (t *Throttle) SetRate(rate Rate) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+	unlimited := t.rate <= 0
+	if !unlimited {
+		// the tokens gathered so far count at the old rate
+		t.tokens += now.Sub(t.last).Seconds() * t.rate
+	}
+	t.last = now
+	t.rate = float64(rate)
+
+	// allow a tenth of a second worth of data to go out at once
+	t.burst = float64(rate) / 10
+	if t.burst < 0x10000 {
+		t.burst = 0x10000
+	}
+
+	if unlimited || t.tokens > t.burst {
+		t.tokens = t.burst
+	}
+}
+
+// Wait blocks until n bytes may be transferred
+func (t *Throttle) Wait(n int) {
+	if t == nil {
+		return
+	}
+
+	t.mutex.Lock()
+	if t.rate <= 0 {
+		t.mutex.Unlock()
+		return
+	}
+
+	now := time.Now()
+	t.tokens += now.Sub(t.last).Seconds() * t.rate
+	if t.tokens > t.burst {
+		t.tokens = t.burst
+	}
+	t.last = now
+
+	// callers reserve their bytes up front, so concurrent callers queue up
+	// behind each other rather than racing for tokens
+	t.tokens -= float64(n)
+	var delay time.Duration
+	if t.tokens < 0 {
+		delay = time.Duration(-t.tokens / t.rate * float64(time.Second))
+	}
+	t.mutex.Unlock()
+
+	time.Sleep(delay)
+}
+
+type throttledConn struct {
+	net.Conn
+	throttles []*Throttle
+}
+
+// NewThrottledConn returns a connection that waits on every throttle before
+// reading or writing.  If there are no throttles conn is returned as is
+func NewThrottledConn(conn net.Conn, throttles ...*Throttle) net.Conn {
+	var active []*Throttle
+	for _, throttle := range throttles {
+		if throttle != nil {
+			active = append(active, throttle)
+		}
+	}
+
+	if len(active) == 0 {
+		return conn
+	}
+
+	return &throttledConn{
+		Conn:      conn,
+		throttles: active,
+	}
+}
+
+func (c *throttledConn) wait(n int) {
+	for _, throttle := range c.throttles {
+		throttle.Wait(n)
+	}
+}
+
+func (c *throttledConn) Read(b []byte) (n int, e error) {
+	n, e = c.Conn.Read(b)
+	c.wait(n)
+	return
+}
+
+func (c *throttledConn) Write(b []byte) (n int, e error) {
+	c.wait(len(b))
+	return c.Conn.Write(b)
+}
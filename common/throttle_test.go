@@ -0,0 +1,85 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	rates := map[string]Rate{
+		"0":    0,
+		"1500": 1500,
+		"512k": 512 << 10,
+		"200M": 200 << 20,
+		"2G":   2 << 30,
+	}
+
+	for s, expected := range rates {
+		rate, err := ParseRate(s)
+		if err != nil {
+			t.Error("Unexpected error parsing ", s, " - ", err.Error())
+		}
+		if rate != expected {
+			t.Error("Expected ", expected, " for ", s, " got ", rate)
+		}
+	}
+
+	for _, s := range []string{"", "M", "fast", "-5M", "1.5M"} {
+		if _, err := ParseRate(s); err == nil {
+			t.Error("Expected error parsing ", s)
+		}
+	}
+
+	rate := Rate(200 << 20)
+	if rate.String() != "200M" {
+		t.Error("Expected 200M got ", rate.String())
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	if NewThrottle(0) != nil {
+		t.Error("A zero rate should not be throttled")
+	}
+
+	rate := Rate(1 << 20)
+	throttle := NewThrottle(rate)
+	start := time.Now()
+	// the first tenth of a second is allowed as a burst, the rest has to wait
+	for i := 0; i < 6; i++ {
+		throttle.Wait(int(rate) / 10)
+	}
+
+	elapsed := time.Since(start)
+	if elapsed < 450*time.Millisecond || elapsed > 900*time.Millisecond {
+		t.Error("Expected about half a second to pass, took ", elapsed)
+	}
+}
+
+func TestThrottleSetRate(t *testing.T) {
+	throttle := &Throttle{}
+	start := time.Now()
+	throttle.Wait(10 << 20)
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Error("Expected a throttle without a rate not to wait, took ", elapsed)
+	}
+
+	// transfers sharing the throttle slow down once a rate is set
+	rate := Rate(1 << 20)
+	throttle.SetRate(rate)
+	start = time.Now()
+	for i := 0; i < 6; i++ {
+		throttle.Wait(int(rate) / 10)
+	}
+
+	elapsed := time.Since(start)
+	if elapsed < 450*time.Millisecond || elapsed > 900*time.Millisecond {
+		t.Error("Expected about half a second to pass, took ", elapsed)
+	}
+
+	throttle.SetRate(0)
+	start = time.Now()
+	throttle.Wait(10 << 20)
+	if elapsed = time.Since(start); elapsed > 100*time.Millisecond {
+		t.Error("Expected lifting the limit to stop waits, took ", elapsed)
+	}
+}
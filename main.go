@@ -1,7 +1,11 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"os"
 
 	"github.com/murphybytes/ucp/client"
 	"github.com/murphybytes/ucp/common"
@@ -10,20 +14,43 @@ import (
 )
 
 func main() {
+	flags, err := common.ParseFlags(os.Args[1:])
+	var usage *common.UsageError
+	if errors.As(err, &usage) {
+		if errors.Is(err, flag.ErrHelp) {
+			fmt.Print(usage.Usage)
+			os.Exit(0)
+		}
+
+		fmt.Fprintln(os.Stderr, "Missing or invalid command line arguments -", err.Error())
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprint(os.Stderr, usage.Usage)
+		os.Exit(common.ExitCode(err))
+	}
+
+	if err != nil {
+		log.Println(err.Error())
+		os.Exit(common.ExitCode(err))
+	}
+
 	udt.Startup()
 	defer udt.Cleanup()
-	flags := common.NewFlags()
 	app := newApplication(flags)
-	err := app.Run()
+	err = app.Run()
 	if err != nil {
-		log.Fatalln(err.Error())
+		log.Println(err.Error())
+		os.Exit(common.ExitCode(err))
 	}
 
 }
 
 func newApplication(f *common.Flags) (app common.Application) {
-	if f.IsServer {
+	if f.GenerateKeys {
+		app = common.NewKeygen(f)
+	} else if f.IsServer {
 		app = server.New(f)
+	} else if f.Admin != nil {
+		app = server.NewAdmin(f)
 	} else {
 		app = client.New(f)
 	}
@@ -0,0 +1,90 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/murphybytes/ucp/common"
+	"github.com/murphybytes/ucp/wire"
+)
+
+// Push copies the local file from to the remote file to, user@host[:port]:path,
+// authenticating with the private key at privateKeyPath.  Servers use it to
+// send a file to another server on behalf of a client.  The file is sent with
+// options and compression no faster than limit.  Canceling ctx stops the copy
+// and discards the partly written file
+func Push(ctx context.Context, from, to, privateKeyPath string, options wire.TransferOption, compression wire.Compression, limit common.Rate) (e error) {
+	var user, host, path string
+	var port int
+	if user, host, port, path, e = ParseSpec(to); e != nil {
+		return
+	}
+
+	var file *os.File
+	if file, e = os.Open(from); e != nil {
+		return
+	}
+	defer file.Close()
+
+	var r *Conn
+	if r, e = Dial(ctx, fmt.Sprint(host, ":", port), user, privateKeyPath, limit); e != nil {
+		return
+	}
+
+	stop := common.CloseOnCancel(ctx, r, common.CancelGracePeriod)
+	defer func() {
+		stop()
+		// tell the destination to discard what it got
+		if r.Transferring() && ctx.Err() != nil {
+			r.Cancel()
+		}
+		r.Close()
+	}()
+
+	request := wire.FileTransferRequest{
+		FilePath:    path,
+		Transfer:    wire.ClientWriting,
+		Options:     options,
+		Compression: compression,
+	}
+
+	if e = r.Begin(ctx, request); e != nil {
+		return
+	}
+
+	if options.Has(wire.DeltaTransfer) {
+		if e = r.WriteDelta(file); e != nil {
+			return
+		}
+	} else {
+		// each write is sent as one packet
+		buffer := make([]byte, wire.DataBufferSize)
+		for {
+			read, err := file.Read(buffer)
+			if read > 0 {
+				if _, e = r.Write(buffer[:read]); e != nil {
+					return
+				}
+			}
+
+			if err == io.EOF {
+				break
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	var md *wire.FileMetadata
+	if options.Has(wire.PreserveMetadata) {
+		if md, e = common.GetFileMetadata(from, options); e != nil {
+			return
+		}
+	}
+
+	return r.FinishWrite(md)
+}
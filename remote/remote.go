@@ -0,0 +1,748 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rsa"
+	"encoding/gob"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/murphybytes/ucp/common"
+	"github.com/murphybytes/ucp/wire"
+	"github.com/murphybytes/udt.go/udt"
+)
+
+const canceledMessage = "Transfer canceled by the client"
+
+// ServerError is an error the server reported in a reply that ended the
+// operation.  The connection is then ready for the next operation
+type ServerError struct {
+	error
+}
+
+func (e ServerError) Unwrap() error {
+	return e.error
+}
+
+// ConnectionError is a failure of the connection to a server.  Unlike the
+// errors a server reports it may not happen again over a new connection
+type ConnectionError struct {
+	Err error
+}
+
+func (e ConnectionError) Error() string {
+	return e.Err.Error()
+}
+
+func (e ConnectionError) Unwrap() error {
+	return e.Err
+}
+
+// failingConn marks every error of its connection as a ConnectionError
+type failingConn struct {
+	net.Conn
+}
+
+func (c failingConn) Read(b []byte) (n int, e error) {
+	if n, e = c.Conn.Read(b); e != nil {
+		e = ConnectionError{e}
+	}
+	return
+}
+
+func (c failingConn) Write(b []byte) (n int, e error) {
+	if n, e = c.Conn.Write(b); e != nil {
+		e = ConnectionError{e}
+	}
+	return
+}
+
+// ParseSpec splits the spec of a remote file, user@host[:port]:path, into its
+// parts.  The port is common.DefaultPort if the spec has none
+func ParseSpec(spec string) (user, host string, port int, path string, e error) {
+	port = common.DefaultPort
+
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return "", "", 0, "", errors.New("Invalid remote file specification - " + spec)
+	}
+
+	path = parts[len(parts)-1]
+	if len(parts) == 3 {
+		if port, e = strconv.Atoi(parts[1]); e != nil {
+			return "", "", 0, "", errors.New("Invalid port format in file spec '" + spec + "'")
+		}
+	}
+
+	userHost := strings.Split(parts[0], "@")
+	if len(userHost) != 2 {
+		return "", "", 0, "", errors.New("Invalid host specification - " + parts[0])
+	}
+
+	return userHost[0], userHost[1], port, path, nil
+}
+
+// Conn is an authenticated connection to a ucp server that performs one
+// operation at a time.  Clients use it for their transfers and servers to push
+// files to other servers
+type Conn struct {
+	conn       net.Conn
+	userName   string
+	publicKey  *rsa.PublicKey
+	privateKey *rsa.PrivateKey
+	// ctx cancels the operation in progress
+	ctx                  context.Context
+	options              wire.TransferOption
+	aesKey               cipher.Block
+	initializationVector []byte
+	compression          wire.Compression
+	size                 int64
+	metadata             *wire.FileMetadata
+	// transferring is true from the start of an operation until the server is
+	// no longer waiting for messages of it
+	transferring bool
+}
+
+// Dial connects to the ucp server at addr, host:port, and authenticates as
+// userName with the private key at privateKeyPath.  Data is sent and received
+// no faster than limit.  ctx limits how long connecting and authenticating may
+// take
+func Dial(ctx context.Context, addr, userName, privateKeyPath string, limit common.Rate) (r *Conn, e error) {
+	r = &Conn{
+		userName: userName,
+		ctx:      context.Background(),
+	}
+
+	if r.privateKey, e = common.GetPrivateKey(privateKeyPath); e != nil {
+		return nil, e
+	}
+
+	type dialed struct {
+		conn net.Conn
+		e    error
+	}
+
+	result := make(chan dialed, 1)
+	go func() {
+		conn, err := udt.Dial(addr)
+		if err != nil {
+			err = ConnectionError{err}
+		}
+		result <- dialed{conn, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		// close the connection if it is made after all
+		go func() {
+			if d := <-result; d.conn != nil {
+				d.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	case d := <-result:
+		if d.e != nil {
+			return nil, d.e
+		}
+		r.conn = failingConn{common.NewThrottledConn(d.conn, common.NewThrottle(limit))}
+	}
+
+	// a handshake has nothing to finish, closing the connection interrupts
+	// it at once
+	stop := common.CloseOnCancel(ctx, r.conn, 0)
+	e = r.authenticate()
+	stop()
+
+	if ctx.Err() != nil {
+		e = ctx.Err()
+	}
+
+	if e != nil {
+		r.conn.Close()
+		return nil, e
+	}
+
+	return
+}
+
+// authenticate exchanges public keys and signs the challenge of the server
+func (r *Conn) authenticate() (e error) {
+
+	var buffer bytes.Buffer
+	encoder := gob.NewEncoder(&buffer)
+
+	authRequest := &wire.AuthenticationRequest{
+		UserName:                      r.userName,
+		RequestedAuthenticationMethod: wire.AuthenticationMethodPublicKey,
+		PublicKey:                     r.privateKey.PublicKey,
+		ProtocolVersion:               wire.ProtocolVersion,
+	}
+
+	if e = encoder.Encode(authRequest); e != nil {
+		return
+	}
+
+	if _, e = r.conn.Write(buffer.Bytes()); e != nil {
+		return
+	}
+
+	response := make([]byte, wire.ReadBufferSize)
+	var read int
+	if read, e = r.conn.Read(response); e != nil {
+		if errors.Is(e, io.EOF) {
+			// servers close connections beyond their limit at once
+			e = ConnectionError{errors.New("The server closed the connection, it may be at capacity, try again later")}
+		}
+		return
+	}
+
+	decoder := gob.NewDecoder(bytes.NewBuffer(response[:read]))
+
+	authResponse := &wire.AutenticationResponse{
+		PublicKey: rsa.PublicKey{
+			N: &big.Int{},
+		},
+	}
+
+	if e = decoder.Decode(authResponse); e != nil {
+		return
+	}
+
+	if authResponse.Status != wire.OK {
+		return common.StatusError(authResponse.Status, authResponse.StatusText)
+	}
+
+	if authResponse.AllowedAuthenticationMethod != wire.AuthenticationMethodPublicKey {
+		return errors.New("Server sent back unexpected method")
+	}
+
+	r.publicKey = &authResponse.PublicKey
+
+	// prove that we hold the private key of the public key we sent
+	proof := wire.AuthenticationProof{}
+	if proof.Signature, e = common.SignChallenge(r.privateKey, authResponse.Challenge, authRequest.UserName); e != nil {
+		return
+	}
+
+	buffer.Reset()
+	if e = gob.NewEncoder(&buffer).Encode(proof); e != nil {
+		return
+	}
+
+	if _, e = r.conn.Write(buffer.Bytes()); e != nil {
+		return
+	}
+
+	if read, e = r.conn.Read(response); e != nil {
+		return
+	}
+
+	var result wire.AuthenticationResult
+	if e = gob.NewDecoder(bytes.NewBuffer(response[:read])).Decode(&result); e != nil {
+		return
+	}
+
+	if result.Status != wire.OK {
+		e = common.StatusError(result.Status, result.StatusText)
+	}
+
+	return
+}
+
+// Begin starts the operation request describes, canceled by ctx.  The
+// operation before it must have finished.  Errors the server reports are
+// ServerErrors
+func (r *Conn) Begin(ctx context.Context, request wire.FileTransferRequest) (e error) {
+	r.ctx = ctx
+	r.options = request.Options
+	r.size = -1
+	r.metadata = nil
+	request.UserName = r.userName
+
+	var buffer bytes.Buffer
+	encoder := gob.NewEncoder(&buffer)
+	if e = encoder.Encode(request); e != nil {
+		return
+	}
+
+	var response []byte
+	if response, e = r.get(buffer.Bytes()); e != nil {
+		return
+	}
+
+	decoderBuffer := bytes.NewBuffer(response)
+	decoder := gob.NewDecoder(decoderBuffer)
+	var txfrResponse wire.FileTransferResponse
+	if e = decoder.Decode(&txfrResponse); e != nil {
+		return
+	}
+
+	if txfrResponse.Status != wire.OK {
+		return ServerError{common.StatusError(txfrResponse.Status, txfrResponse.StatusText)}
+	}
+
+	if r.aesKey, e = aes.NewCipher(txfrResponse.AESKey); e != nil {
+		return
+	}
+
+	r.initializationVector = txfrResponse.InitializationVector
+	r.compression = txfrResponse.Compression
+	r.size = txfrResponse.FileSize
+	r.transferring = true
+
+	return
+}
+
+// Size is the size of the file the operation in progress reads, -1 if the
+// server doesn't know it
+func (r *Conn) Size() int64 {
+	return r.size
+}
+
+// Transferring returns true until the server is no longer waiting for
+// messages of the operation in progress
+func (r *Conn) Transferring() bool {
+	return r.transferring
+}
+
+// Finished records that the server is no longer waiting for messages of the
+// operation in progress
+func (r *Conn) Finished() {
+	r.transferring = false
+}
+
+func (r *Conn) get(request []byte) (response []byte, e error) {
+	if e = r.ctx.Err(); e != nil {
+		return
+	}
+
+	var encryptedRequestBuffer []byte
+	if encryptedRequestBuffer, e = common.EncryptOAEP(r.publicKey, request); e != nil {
+		return
+	}
+
+	if _, e = r.conn.Write(encryptedRequestBuffer); e != nil {
+		return
+	}
+
+	encryptedResponseBuffer := make([]byte, wire.ReadBufferSize)
+	var read int
+	if read, e = r.conn.Read(encryptedResponseBuffer); e != nil {
+		return
+	}
+
+	var responseBuffer []byte
+	if responseBuffer, e = common.DecryptOAEP(r.privateKey, encryptedResponseBuffer[:read]); e != nil {
+		return
+	}
+
+	return responseBuffer, nil
+
+}
+
+func (r *Conn) Read(buff []byte) (n int, e error) {
+	iv := r.initializationVector
+	var response *wire.ClientDataResponse
+	if response, e = r.requestData(); e != nil {
+		return
+	}
+
+	// file data follows the response encrypted with the same initialization
+	// vector
+	readBuffer := make([]byte, wire.ReadBufferSize)
+	if n, e = r.read(readBuffer); e != nil {
+		return
+	}
+
+	data := common.DecryptAES(r.aesKey, iv, readBuffer[:n])
+	if response.Compressed {
+		if data, e = common.DecompressChunk(data); e != nil {
+			return 0, e
+		}
+	}
+
+	n = copy(buff, data)
+
+	return
+}
+
+// requestData asks the server for the next packet of the file and returns the
+// response.  At the end of the file it returns io.EOF
+func (r *Conn) requestData() (response *wire.ClientDataResponse, e error) {
+	request := wire.ClientDataRequest{
+		Status:     wire.More,
+		StatusText: "More",
+	}
+
+	if e = r.sendAES(request); e != nil {
+		return
+	}
+
+	response = &wire.ClientDataResponse{}
+	if e = r.receiveAES(response); e != nil {
+		return nil, e
+	}
+
+	if response.Status != wire.OK && response.Status != wire.EOF {
+		return nil, r.failed(response.Status, response.StatusText)
+	}
+
+	r.initializationVector = response.NextInitializationVector
+
+	if response.Status == wire.EOF {
+		r.Finished()
+		if r.options.Has(wire.PreserveMetadata) {
+			if e = r.readMetadata(); e != nil {
+				return nil, e
+			}
+		}
+		return nil, io.EOF
+	}
+
+	return
+}
+
+// ReadDelta requests the file as a delta against the existing file at
+// basisPath and writes the rebuilt file to w
+func (r *Conn) ReadDelta(basisPath string, w io.Writer) (e error) {
+	var signatures []wire.BlockSignature
+	var basis io.ReaderAt
+	// without an existing file the server sends every byte
+	if f, err := os.Open(basisPath); err == nil {
+		defer f.Close()
+		if signatures, e = common.ComputeSignatures(f, wire.DeltaBlockSize); e != nil {
+			return
+		}
+		basis = f
+	}
+
+	if e = r.sendSignatures(signatures); e != nil {
+		return
+	}
+
+	for {
+		var response *wire.ClientDataResponse
+		if response, e = r.requestData(); e == io.EOF {
+			return nil
+		}
+
+		if e != nil {
+			return
+		}
+
+		if e = common.ApplyDelta(basis, wire.DeltaBlockSize, len(signatures), response.Delta, w); e != nil {
+			return
+		}
+	}
+}
+
+func (r *Conn) sendSignatures(signatures []wire.BlockSignature) (e error) {
+	for sent := 0; ; {
+		count := len(signatures) - sent
+		if count > wire.MaxSignaturesPerPacket {
+			count = wire.MaxSignaturesPerPacket
+		}
+
+		packet := wire.SignaturePacket{
+			BlockSize:  wire.DeltaBlockSize,
+			Signatures: signatures[sent : sent+count],
+			Status:     wire.More,
+			StatusText: "More",
+		}
+
+		sent += count
+		if sent == len(signatures) {
+			packet.Status = wire.EOF
+			packet.StatusText = "EOF"
+		}
+
+		if e = r.sendAES(packet); e != nil {
+			return
+		}
+
+		if e = r.ReceiveResponse(); e != nil {
+			return
+		}
+
+		if packet.Status == wire.EOF {
+			return
+		}
+	}
+}
+
+// ReadListing requests the entries of a directory listing
+func (r *Conn) ReadListing() (entries []wire.FileEntry, e error) {
+	for {
+		request := wire.ClientDataRequest{
+			Status:     wire.More,
+			StatusText: "More",
+		}
+
+		if e = r.sendAES(request); e != nil {
+			return
+		}
+
+		var packet wire.ListingPacket
+		if e = r.receiveAES(&packet); e != nil {
+			return
+		}
+
+		if packet.Status != wire.More && packet.Status != wire.EOF {
+			return nil, r.failed(packet.Status, packet.StatusText)
+		}
+
+		r.initializationVector = packet.NextInitializationVector
+		entries = append(entries, packet.Entries...)
+
+		if packet.Status == wire.EOF {
+			r.Finished()
+			return
+		}
+	}
+}
+
+// WriteDelta sends the data read from rd as a delta against the server's copy
+// of the file
+func (r *Conn) WriteDelta(rd io.Reader) (e error) {
+	var signatures []wire.BlockSignature
+	blockSize := wire.DeltaBlockSize
+
+	for {
+		request := wire.ClientDataRequest{
+			Status:     wire.More,
+			StatusText: "More",
+		}
+
+		if e = r.sendAES(request); e != nil {
+			return
+		}
+
+		var packet wire.SignaturePacket
+		if e = r.receiveAES(&packet); e != nil {
+			return
+		}
+
+		if packet.Status != wire.More && packet.Status != wire.EOF {
+			return r.failed(packet.Status, packet.StatusText)
+		}
+
+		r.initializationVector = packet.NextInitializationVector
+		signatures = append(signatures, packet.Signatures...)
+		blockSize = packet.BlockSize
+
+		if packet.Status == wire.EOF {
+			break
+		}
+	}
+
+	if blockSize <= 0 {
+		return errors.New("Invalid delta block size")
+	}
+
+	encoder := common.NewDeltaEncoder(rd, signatures, blockSize)
+	for {
+		var ops []wire.DeltaOp
+		if ops, e = encoder.Next(); e == io.EOF {
+			return nil
+		}
+
+		if e != nil {
+			return
+		}
+
+		clientRead := &wire.ClientRead{
+			Delta:      ops,
+			Status:     wire.More,
+			StatusText: "More",
+		}
+
+		if e = r.sendClientRead(clientRead); e != nil {
+			return
+		}
+	}
+}
+
+func (r *Conn) readMetadata() (e error) {
+	var md wire.FileMetadata
+	if e = r.receiveAES(&md); e != nil {
+		return
+	}
+
+	if md.Status != wire.OK {
+		return r.failed(md.Status, md.StatusText)
+	}
+
+	r.metadata = &md
+
+	return
+}
+
+// Metadata returns the attributes of the file the server sent after its last
+// data packet, nil if it sent none
+func (r *Conn) Metadata() *wire.FileMetadata {
+	return r.metadata
+}
+
+func (r *Conn) Write(buff []byte) (n int, e error) {
+	data, compressed, e := common.CompressChunk(r.compression, buff)
+	if e != nil {
+		return
+	}
+
+	clientRead := &wire.ClientRead{
+		Buffer:     data,
+		Compressed: compressed,
+		Status:     wire.More,
+		StatusText: "More",
+	}
+
+	if e = r.sendClientRead(clientRead); e != nil {
+		return
+	}
+
+	return len(buff), nil
+}
+
+// sendClientRead sends a packet of file data and waits for the server to
+// acknowledge it
+func (r *Conn) sendClientRead(clientRead *wire.ClientRead) (e error) {
+	if e = r.sendAES(clientRead); e != nil {
+		return
+	}
+
+	return r.ReceiveResponse()
+}
+
+// ReceiveResponse waits for the server to answer the last message
+func (r *Conn) ReceiveResponse() (e error) {
+	var response wire.ClientReadResponse
+	if e = r.receiveAES(&response); e != nil {
+		return
+	}
+
+	if response.Status != wire.OK {
+		return r.failed(response.Status, response.StatusText)
+	}
+
+	r.initializationVector = response.NextInitializationVector
+
+	return
+}
+
+// ConfirmKey starts an operation that changes files by proving to the server
+// that the AES key could be decrypted.  The server answers once the change is
+// made
+func (r *Conn) ConfirmKey() error {
+	return r.sendAES(wire.KeyConfirmation{
+		InitializationVector: r.initializationVector,
+		Status:               wire.OK,
+		StatusText:           "OK",
+	})
+}
+
+// FinishWrite tells the server there is no more data, sends md if it is not
+// nil and waits for the server to confirm the file was stored
+func (r *Conn) FinishWrite(md *wire.FileMetadata) (e error) {
+	defer r.Finished()
+
+	eof := &wire.ClientRead{
+		Status:     wire.EOF,
+		StatusText: "EOF",
+	}
+
+	if e = r.sendAES(eof); e != nil {
+		return
+	}
+
+	if md != nil {
+		if e = r.sendAES(md); e != nil {
+			return
+		}
+	}
+
+	return r.ReceiveResponse()
+}
+
+// sendAES encodes msg and sends it encrypted with the current initialization
+// vector.  Once the operation is canceled the server is told instead
+func (r *Conn) sendAES(msg interface{}) (e error) {
+	if e = r.ctx.Err(); e != nil {
+		r.Cancel()
+		return
+	}
+
+	return r.send(msg)
+}
+
+// failed ends the operation in progress with an error the server reported.
+// A server that cancelled the session closes the connection after the reply
+func (r *Conn) failed(status wire.ResponseCode, statusText string) error {
+	r.Finished()
+	if status == wire.Canceled {
+		return common.StatusError(status, statusText)
+	}
+
+	return ServerError{common.StatusError(status, statusText)}
+}
+
+// Cancel tells the server waiting for the next message of the operation in
+// progress that it was canceled.  No reply follows
+func (r *Conn) Cancel() error {
+	r.Finished()
+
+	// every message the server waits for has a Status
+	return r.send(wire.StatusResponse{
+		Status:     wire.Canceled,
+		StatusText: canceledMessage,
+	})
+}
+
+func (r *Conn) send(msg interface{}) (e error) {
+	var encoderBuffer bytes.Buffer
+	encoder := gob.NewEncoder(&encoderBuffer)
+	if e = encoder.Encode(msg); e != nil {
+		return
+	}
+
+	encrypted := common.EncryptAES(r.aesKey, r.initializationVector, encoderBuffer.Bytes())
+	_, e = r.conn.Write(encrypted)
+
+	return
+}
+
+// receiveAES reads a message encrypted with the current initialization vector
+// and decodes it into msg
+func (r *Conn) receiveAES(msg interface{}) (e error) {
+	readBuffer := make([]byte, wire.ReadBufferSize)
+
+	var read int
+	if read, e = r.read(readBuffer); e != nil {
+		return
+	}
+
+	decrypted := common.DecryptAES(r.aesKey, r.initializationVector, readBuffer[:read])
+	decoder := gob.NewDecoder(bytes.NewBuffer(decrypted))
+
+	return decoder.Decode(msg)
+}
+
+// read reads a message from the connection.  The end of the file is sent as a
+// message, so a connection closed by the server is an error
+func (r *Conn) read(b []byte) (n int, e error) {
+	if n, e = r.conn.Read(b); errors.Is(e, io.EOF) {
+		e = ConnectionError{io.ErrUnexpectedEOF}
+	}
+	return
+}
+
+func (r *Conn) Close() error {
+	return r.conn.Close()
+}
@@ -1,4 +1,4 @@
-package client
+package remote
 
 import (
 	"bytes"
@@ -6,6 +6,7 @@ import (
 	"crypto/rsa"
 	"encoding/gob"
 	"math/big"
+	"testing"
 
 	"github.com/murphybytes/ucp/common"
 	"github.com/murphybytes/udt.go/udt"
@@ -45,11 +46,11 @@ func (r *requestorTestConn) Write(b []byte) (n int, e error) {
 	return
 }
 
-func newTestRequestor() (r requester) {
+func newTestConn() (r *Conn) {
 
 	privateKey, _ := rsa.GenerateKey(rand.Reader, common.KeySize)
 
-	r = &server{
+	r = &Conn{
 		privateKey: privateKey,
 		conn:       &requestorTestConn{},
 	}
@@ -59,14 +60,14 @@ func newTestRequestor() (r requester) {
 
 // func TestInitializeSecureChannel(t *testing.T) {
 // 	var e error
-// 	req := newTestRequestor()
+// 	req := newTestConn()
 // 	if e = req.initializeSecureChannel(); e != nil {
 // 		t.Fatal("expected success ", e.Error())
 // 	}
 //
 // 	testMsg := "this is a test message"
 // 	var encrypted, decrypted []byte
-// 	srv := req.(*server)
+// 	srv := req
 // 	conn := srv.conn.(*requestorTestConn)
 // 	// client to server
 // 	if encrypted, e = common.EncryptOAEP(srv.publicKey, []byte(testMsg)); e != nil {
@@ -93,3 +94,24 @@ func newTestRequestor() (r requester) {
 // 	}
 //
 // }
+
+func TestParseSpec(t *testing.T) {
+	user, host, port, path, e := ParseSpec("john@foo.com:1234:/home/john")
+	if e != nil {
+		t.Fatal("Expected the spec to parse, got ", e)
+	}
+
+	if user != "john" || host != "foo.com" || port != 1234 || path != "/home/john" {
+		t.Error("Unexpected parts ", user, " ", host, " ", port, " ", path)
+	}
+
+	if _, _, port, _, e = ParseSpec("john@foo.com:/home/john"); e != nil || port != common.DefaultPort {
+		t.Error("Expected the default port, got ", port, " ", e)
+	}
+
+	for _, spec := range []string{"/home/john", "foo.com:/home/john", "john@foo.com:port:/home/john"} {
+		if _, _, _, _, e = ParseSpec(spec); e == nil {
+			t.Error("Expected ", spec, " to be rejected")
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/murphybytes/ucp/common"
+)
+
+// serveAdmin serves the admin API over HTTP on a Unix socket at path.
+//
+//	GET  /sessions             lists the sessions in progress
+//	POST /sessions/{id}/cancel cancels a session
+func (s *Server) serveAdmin(path string) (listener net.Listener, e error) {
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		// a socket nobody answers on was left behind by a server that died
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, errors.New("Admin socket " + path + " is used by another server")
+		}
+		os.Remove(path)
+	}
+
+	if listener, e = listenPrivate(path); e != nil {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sessions", s.listSessions)
+	mux.HandleFunc("/sessions/", s.cancelSession)
+	go http.Serve(listener, mux)
+
+	return
+}
+
+// listenPrivate listens on a Unix socket at path that only the user running
+// the server can connect to.  The socket is made in a directory nobody else
+// can enter and moved to path once its mode is 0600, so there is no moment it
+// accepts anyone else's connection.  A missing parent directory such as
+// ~/.ucp is created for the user alone
+func listenPrivate(path string) (listener net.Listener, e error) {
+	if e = os.MkdirAll(filepath.Dir(path), 0700); e != nil {
+		return
+	}
+
+	var dir string
+	if dir, e = ioutil.TempDir(filepath.Dir(path), ".ucp-admin-"); e != nil {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	private := filepath.Join(dir, filepath.Base(path))
+	if listener, e = net.Listen("unix", private); e != nil {
+		return
+	}
+
+	if e = os.Chmod(private, 0600); e == nil {
+		e = os.Rename(private, path)
+	}
+
+	if e != nil {
+		listener.Close()
+		return nil, e
+	}
+
+	return &adminListener{Listener: listener, path: path}, nil
+}
+
+// adminListener removes its socket once closed, the listener it wraps only
+// knows where the socket was made
+type adminListener struct {
+	net.Listener
+	path string
+}
+
+func (l *adminListener) Close() error {
+	os.Remove(l.path)
+	return l.Listener.Close()
+}
+
+func (s *Server) listSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mutex.Lock()
+	sessions := make([]sessionInfo, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		sessions = append(sessions, sess.info())
+	}
+	s.mutex.Unlock()
+
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].ID < sessions[j].ID })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+func (s *Server) cancelSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idText := strings.TrimPrefix(r.URL.Path, "/sessions/")
+	if !strings.HasSuffix(idText, "/cancel") {
+		http.NotFound(w, r)
+		return
+	}
+
+	idText = strings.TrimSuffix(idText, "/cancel")
+	id, e := strconv.ParseInt(idText, 10, 64)
+	if e != nil {
+		http.Error(w, "Invalid session id", http.StatusBadRequest)
+		return
+	}
+
+	s.mutex.Lock()
+	sess, ok := s.sessions[id]
+	s.mutex.Unlock()
+
+	if !ok {
+		http.Error(w, "No session "+idText, http.StatusNotFound)
+		return
+	}
+
+	s.currentLogger().LogInfo("Cancelling session ", id)
+	sess.cancel()
+}
+
+// Admin runs the ucp admin subcommands against the admin socket of a server
+// on this host
+type Admin struct {
+	flags  *common.Flags
+	client *http.Client
+}
+
+// NewAdmin creates an Admin
+func NewAdmin(flags *common.Flags) common.Application {
+	return &Admin{
+		flags: flags,
+		client: &http.Client{
+			Transport: &http.Transport{
+				Dial: func(network, address string) (net.Conn, error) {
+					return net.Dial("unix", flags.AdminSocket)
+				},
+			},
+		},
+	}
+}
+
+// Run the admin subcommand, either sessions or cancel followed by a session id
+func (a *Admin) Run() (e error) {
+	args := a.flags.Admin
+	switch {
+	case len(args) == 0 || args[0] == "sessions":
+		return a.sessions()
+	case args[0] == "cancel" && len(args) == 2:
+		return a.cancel(args[1])
+	}
+
+	return errors.New("usage: ucp admin [sessions | cancel <session id>]")
+}
+
+func (a *Admin) sessions() (e error) {
+	var response *http.Response
+	if response, e = a.client.Get("http://ucp/sessions"); e != nil {
+		return
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return errors.New(response.Status)
+	}
+
+	var sessions []sessionInfo
+	if e = json.NewDecoder(response.Body).Decode(&sessions); e != nil {
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tUSER\tREMOTE\tDIRECTION\tPATH\tBYTES\tRATE\tDURATION")
+	for _, sess := range sessions {
+		duration := time.Since(sess.Started).Truncate(time.Second)
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s/s\t%s\n", sess.ID, sess.User, sess.Remote, sess.Direction, sess.Path,
+			common.FormatBytes(float64(sess.Bytes)), common.FormatBytes(sess.Rate), duration)
+	}
+
+	return w.Flush()
+}
+
+func (a *Admin) cancel(id string) (e error) {
+	var response *http.Response
+	if response, e = a.client.Post("http://ucp/sessions/"+id+"/cancel", "", nil); e != nil {
+		return
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(response.Body)
+		return errors.New(strings.TrimSpace(string(message)))
+	}
+
+	fmt.Println("Cancelled session", id)
+	return
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/murphybytes/ucp/common"
+)
+
+func TestAdminSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ucp-admin")
+	if err != nil {
+		t.Fatal("Could not create directory ", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	// the directory of the socket is created like ~/.ucp on a new server
+	flags := &common.Flags{LogLevel: "ERROR", AdminSocket: filepath.Join(dir, ".ucp", "admin.sock")}
+	logger, _ := common.NewLogger(flags)
+	s := New(flags).(*Server)
+	s.logger = logger
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	sess := newSession(context.Background(), 7, conn)
+	sess.setUser("alice", "SHA256:x")
+	sess.startTransfer("/data/file", "read")
+	sess.digest.Write(make([]byte, 100))
+	s.sessions[7] = sess
+
+	listener, err := s.serveAdmin(flags.AdminSocket)
+	if err != nil {
+		t.Fatal("Could not serve admin API ", err.Error())
+	}
+	defer listener.Close()
+
+	if info, err := os.Stat(flags.AdminSocket); err != nil || info.Mode().Perm() != 0600 {
+		t.Error("Expected the socket to be private to the user ", info, err)
+	}
+
+	if info, err := os.Stat(filepath.Dir(flags.AdminSocket)); err != nil || info.Mode().Perm() != 0700 {
+		t.Error("Expected the directory of the socket to be private to the user ", info, err)
+	}
+
+	if _, err = s.serveAdmin(flags.AdminSocket); err == nil {
+		t.Error("Expected a second server to be refused the socket")
+	}
+
+	admin := NewAdmin(flags).(*Admin)
+	response, err := admin.client.Get("http://ucp/sessions")
+	if err != nil {
+		t.Fatal("Listing sessions failed ", err.Error())
+	}
+
+	var sessions []sessionInfo
+	err = json.NewDecoder(response.Body).Decode(&sessions)
+	response.Body.Close()
+	if err != nil || len(sessions) != 1 {
+		t.Fatal("Expected one session got ", sessions, err)
+	}
+
+	if info := sessions[0]; info.ID != 7 || info.User != "alice" || info.Path != "/data/file" || info.Bytes != 100 {
+		t.Error("Unexpected session ", info)
+	}
+
+	if err = admin.cancel("8"); err == nil {
+		t.Error("Expected cancelling an unknown session to fail")
+	}
+
+	if err = admin.cancel("7"); err != nil {
+		t.Fatal("Cancel failed ", err.Error())
+	}
+
+	if !sess.isCancelled() || sess.ctx.Err() == nil {
+		t.Error("Expected session to be cancelled")
+	}
+
+	listener.Close()
+	if entries, _ := ioutil.ReadDir(filepath.Dir(flags.AdminSocket)); len(entries) != 0 {
+		t.Error("Expected closing the listener to remove the socket, found ", len(entries), " files")
+	}
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// auditRecord is one line of the audit log.  A record is written for every
+// session that got as far as naming its user
+type auditRecord struct {
+	Time           time.Time `json:"time"`
+	Session        int64     `json:"session"`
+	User           string    `json:"user"`
+	KeyFingerprint string    `json:"key_fingerprint"`
+	Remote         string    `json:"remote"`
+	Path           string    `json:"path,omitempty"`
+	Direction      string    `json:"direction,omitempty"`
+	NewPath        string    `json:"new_path,omitempty"`
+	Bytes          int64     `json:"bytes"`
+	Duration       float64   `json:"duration_seconds"`
+	Result         string    `json:"result"`
+	Error          string    `json:"error,omitempty"`
+	// Digest is the SHA-256 of the file data that was read or written
+	Digest string `json:"digest,omitempty"`
+	// RequestedUser is the user a transfer asked to act as if it isn't User,
+	// such transfers are rejected
+	RequestedUser string `json:"requested_user,omitempty"`
+}
+
+// auditLog appends records as JSON lines.  A nil auditLog discards them
+type auditLog struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+func openAuditLog(path string) (a *auditLog, e error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	var f *os.File
+	if f, e = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600); e != nil {
+		return
+	}
+
+	return &auditLog{file: f}, nil
+}
+
+func (a *auditLog) write(record *auditRecord) (e error) {
+	if a == nil {
+		return nil
+	}
+
+	var line []byte
+	if line, e = json.Marshal(record); e != nil {
+		return
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	_, e = a.file.Write(append(line, '\n'))
+	return
+}
+
+func (a *auditLog) close() error {
+	if a == nil {
+		return nil
+	}
+
+	return a.file.Close()
+}
+
+// auditDigest counts and hashes the file data of a transfer.  The count can be
+// read while the transfer writes
+type auditDigest struct {
+	bytes int64
+	hash  hash.Hash
+}
+
+func newAuditDigest() *auditDigest {
+	return &auditDigest{hash: sha256.New()}
+}
+
+func (d *auditDigest) Write(b []byte) (int, error) {
+	atomic.AddInt64(&d.bytes, int64(len(b)))
+	return d.hash.Write(b)
+}
+
+func (d *auditDigest) count() int64 {
+	return atomic.LoadInt64(&d.bytes)
+}
+
+func (d *auditDigest) sum() string {
+	return hex.EncodeToString(d.hash.Sum(nil))
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/murphybytes/ucp/wire"
+)
+
+func TestAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ucp-audit")
+	if err != nil {
+		t.Fatal("Could not create directory ", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	audit, err := openAuditLog(path)
+	if err != nil {
+		t.Fatal("Could not open audit log ", err.Error())
+	}
+	defer audit.close()
+
+	ctx := &sessionContext{
+		audit:  audit,
+		digest: newAuditDigest(),
+		record: &auditRecord{
+			Time:      time.Now(),
+			Session:   3,
+			User:      "alice",
+			Path:      "/data/file",
+			Direction: wire.ClientWriting.String(),
+		},
+	}
+	ctx.digest.Write([]byte("hello"))
+	writeAuditRecord(ctx, nil)
+
+	// sessions that never named a user are not recorded
+	writeAuditRecord(&sessionContext{audit: audit, digest: newAuditDigest(), record: &auditRecord{}}, errors.New("bad handshake"))
+
+	ctx.record = &auditRecord{User: "bob", RequestedUser: "root", Direction: wire.ClientRemoving.String()}
+	writeAuditRecord(ctx, errors.New("permission denied"))
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal("Could not read audit log ", err.Error())
+	}
+	defer f.Close()
+
+	var records []auditRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record auditRecord
+		if err = json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatal("Invalid audit line ", scanner.Text())
+		}
+		records = append(records, record)
+	}
+
+	if len(records) != 2 {
+		t.Fatal("Expected 2 records got ", len(records))
+	}
+
+	ok := records[0]
+	if ok.User != "alice" || ok.Session != 3 || ok.Bytes != 5 || ok.Result != "ok" {
+		t.Error("Unexpected record ", ok)
+	}
+
+	// sha256 of hello
+	if ok.Digest != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Error("Unexpected digest ", ok.Digest)
+	}
+
+	failed := records[1]
+	if failed.Result != "error" || failed.Error != "permission denied" || failed.Digest != "" || failed.RequestedUser != "root" {
+		t.Error("Unexpected record ", failed)
+	}
+}
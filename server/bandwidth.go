@@ -0,0 +1,74 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/murphybytes/ucp/common"
+)
+
+// bandwidth holds the server wide throttle and a throttle per user so that
+// the sessions of a user share the user's cap.  The throttles exist without a
+// limit too, so sessions in progress follow limits a reload sets
+type bandwidth struct {
+	total     *common.Throttle
+	userLimit common.Rate
+	mutex     sync.Mutex
+	users     map[string]*userThrottle
+}
+
+// userThrottle is the throttle of a user and how many sessions share it
+type userThrottle struct {
+	throttle *common.Throttle
+	sessions int
+}
+
+func newBandwidth(flags *common.Flags) *bandwidth {
+	b := &bandwidth{
+		total: &common.Throttle{},
+		users: make(map[string]*userThrottle),
+	}
+	b.setLimits(flags)
+
+	return b
+}
+
+// setLimits changes the rates of the throttles to the limits of flags
+func (b *bandwidth) setLimits(flags *common.Flags) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.total.SetRate(flags.Limit)
+	b.userLimit = flags.UserLimit
+	for _, user := range b.users {
+		user.throttle.SetRate(b.userLimit)
+	}
+}
+
+// acquire returns the throttles that apply to a session of userName.  The
+// returned function must be called when the session ends, the throttle of a
+// user is dropped with their last session
+func (b *bandwidth) acquire(userName string) (throttles []*common.Throttle, release func()) {
+	if b == nil {
+		return nil, func() {}
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	user, ok := b.users[userName]
+	if !ok {
+		user = &userThrottle{throttle: &common.Throttle{}}
+		user.throttle.SetRate(b.userLimit)
+		b.users[userName] = user
+	}
+	user.sessions++
+
+	return []*common.Throttle{b.total, user.throttle}, func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+
+		if user.sessions--; user.sessions == 0 {
+			delete(b.users, userName)
+		}
+	}
+}
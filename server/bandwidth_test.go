@@ -0,0 +1,60 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/murphybytes/ucp/common"
+)
+
+func TestBandwidthUserThrottles(t *testing.T) {
+	b := newBandwidth(&common.Flags{UserLimit: 1000})
+
+	first, releaseFirst := b.acquire("alice")
+	second, releaseSecond := b.acquire("alice")
+	if first[1] != second[1] {
+		t.Error("Expected the sessions of a user to share a throttle")
+	}
+
+	other, releaseOther := b.acquire("bob")
+	if other[1] == first[1] {
+		t.Error("Expected users to have throttles of their own")
+	}
+
+	releaseFirst()
+	if len(b.users) != 2 {
+		t.Error("Expected the throttle to stay while a session of the user runs")
+	}
+
+	releaseSecond()
+	releaseOther()
+	if len(b.users) != 0 {
+		t.Error("Expected throttles to be dropped with the last session of their user, ", len(b.users), " left")
+	}
+}
+
+func TestBandwidthSetLimits(t *testing.T) {
+	b := newBandwidth(&common.Flags{})
+
+	before, release := b.acquire("alice")
+	defer release()
+
+	b.setLimits(&common.Flags{Limit: 1 << 20, UserLimit: 256 << 10})
+	after, releaseAfter := b.acquire("alice")
+	defer releaseAfter()
+
+	if before[0] != after[0] || before[1] != after[1] {
+		t.Error("Expected sessions started before and after a reload to share throttles")
+	}
+
+	// the new user limit slows the session that started without one, after a
+	// burst of 64K the rest waits a quarter of a second per 64K
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		before[1].Wait(0x10000)
+	}
+
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+		t.Error("Expected the user limit to apply to the session in progress, took ", elapsed)
+	}
+}
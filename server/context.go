@@ -1,20 +1,49 @@
 package server
 
 import (
+	"context"
+	"crypto/rsa"
 	"net"
 
 	"github.com/murphybytes/ucp/common"
+	"github.com/murphybytes/ucp/storage"
 )
 
-type context struct {
+type sessionContext struct {
+	// done once the session is cancelled or the server shuts down
+	ctx    context.Context
 	flags  *common.Flags
 	conn   net.Conn
 	logger common.Logger
 	connID int64
+	// userName is the user the client authenticated as, every transfer of
+	// the session acts as this user
+	userName  string
+	bandwidth *bandwidth
+	// throttles limit the bandwidth of the session once it authenticated
+	throttles []*common.Throttle
+	// decides which keys may connect as which users
+	authenticator common.Authenticator
+	// rejects transfers if it returns an error, may be nil
+	authorize func(*Request) error
+	storage   storage.FS
+	// used for every user if not nil
+	privateKey *rsa.PrivateKey
+	limits     *sessionLimits
+	// release ends the session's count against the limits and its share of
+	// the user's throttle
+	release func()
+	audit   *auditLog
+	metrics *metrics
+	// filled in as the session progresses and written to the audit log when
+	// it ends
+	session *session
+	record  *auditRecord
+	digest  *auditDigest
 }
 
-func newContext(flags *common.Flags, conn net.Conn) *context {
-	return &context{
+func newSessionContext(flags *common.Flags, conn net.Conn) *sessionContext {
+	return &sessionContext{
 		flags: flags,
 		conn:  conn,
 	}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"os"
+	"os/user"
+	"sync"
+
+	"github.com/murphybytes/ucp/common"
+)
+
+// authorizedKeys caches the authorized_keys file of each user.  The files are
+// read the first time a user connects, a new authorizedKeys is created when
+// the server reloads.  Users without an authorized_keys file can't connect
+type authorizedKeys struct {
+	mutex sync.Mutex
+	users map[string][]rsa.PublicKey
+	path  func(userName string) (string, error)
+}
+
+func newAuthorizedKeys() *authorizedKeys {
+	return &authorizedKeys{
+		users: make(map[string][]rsa.PublicKey),
+		path:  getAuthorizedKeysPath,
+	}
+}
+
+// Authenticate returns true if key may be used to connect as userName
+func (k *authorizedKeys) Authenticate(userName string, key *rsa.PublicKey) (ok bool, e error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	keys, loaded := k.users[userName]
+	if !loaded {
+		var path string
+		if path, e = k.path(userName); e != nil {
+			return
+		}
+
+		// a missing file authorizes no keys
+		if keys, e = common.ReadAuthorizedKeys(path); e != nil && !os.IsNotExist(e) {
+			return
+		}
+
+		e = nil
+		k.users[userName] = keys
+	}
+
+	for _, authorizedKey := range keys {
+		if authorizedKey.E == key.E && authorizedKey.N.Cmp(key.N) == 0 {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func getAuthorizedKeysPath(userName string) (path string, e error) {
+	var u *user.User
+	if u, e = user.Lookup(userName); e != nil {
+		return
+	}
+
+	return fmt.Sprint(u.HomeDir, "/.ucp/authorized_keys"), nil
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/murphybytes/ucp/common"
+)
+
+func TestAuthorizedKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ucp-keys")
+	if err != nil {
+		t.Fatal("Could not create directory ", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	allowed, _ := rsa.GenerateKey(rand.Reader, 1024)
+	other, _ := rsa.GenerateKey(rand.Reader, 1024)
+
+	encoded, err := common.CreateBase64EncodedPublicKey(allowed.PublicKey)
+	if err != nil {
+		t.Fatal("Key encoding failed ", err.Error())
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(dir, "alice"), encoded, 0600); err != nil {
+		t.Fatal("Could not write authorized keys ", err.Error())
+	}
+
+	keys := newAuthorizedKeys()
+	keys.path = func(userName string) (string, error) {
+		return filepath.Join(dir, userName), nil
+	}
+
+	if ok, err := keys.Authenticate("alice", &allowed.PublicKey); !ok || err != nil {
+		t.Error("Expected authorized key to be accepted ", err)
+	}
+
+	if ok, _ := keys.Authenticate("alice", &other.PublicKey); ok {
+		t.Error("Expected unknown key to be rejected")
+	}
+
+	// users without an authorized_keys file can't connect
+	if ok, err := keys.Authenticate("bob", &other.PublicKey); ok || err != nil {
+		t.Error("Expected key to be rejected without authorized_keys ", err)
+	}
+
+	// the file is cached until the server reloads
+	os.Remove(filepath.Join(dir, "alice"))
+	if ok, _ := keys.Authenticate("alice", &other.PublicKey); ok {
+		t.Error("Expected cached keys to be used")
+	}
+}
@@ -0,0 +1,318 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/murphybytes/ucp/common"
+	"github.com/murphybytes/ucp/wire"
+)
+
+// durationBuckets are the upper bounds in seconds of the transfer duration
+// histogram
+var durationBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 3600}
+
+// metrics are the server's Prometheus metrics.  A nil metrics records nothing
+type metrics struct {
+	sessions        *metricVec
+	authFailures    *metricVec
+	bytesReceived   *metricVec
+	bytesSent       *metricVec
+	activeTransfers *metricVec
+	transfers       *metricVec
+	errors          *metricVec
+	durations       *histogramVec
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		sessions:        newMetricVec("ucp_sessions_total", "counter", "Connections accepted."),
+		authFailures:    newMetricVec("ucp_auth_failures_total", "counter", "Sessions rejected during authentication by method.", "method"),
+		bytesReceived:   newMetricVec("ucp_bytes_received_total", "counter", "Bytes read from clients."),
+		bytesSent:       newMetricVec("ucp_bytes_sent_total", "counter", "Bytes written to clients."),
+		activeTransfers: newMetricVec("ucp_active_transfers", "gauge", "Transfers in progress by direction.", "direction"),
+		transfers:       newMetricVec("ucp_transfers_total", "counter", "Finished transfers by direction and result.", "direction", "result"),
+		errors:          newMetricVec("ucp_errors_total", "counter", "Failed sessions and transfers by error code.", "code"),
+		durations:       newHistogramVec("ucp_transfer_duration_seconds", "Duration of transfers by direction.", durationBuckets, "direction"),
+	}
+}
+
+func (m *metrics) sessionStarted() {
+	if m != nil {
+		m.sessions.add(1)
+	}
+}
+
+func (m *metrics) authFailed(method string) {
+	if m != nil {
+		m.authFailures.add(1, method)
+	}
+}
+
+func (m *metrics) transferStarted(direction string) {
+	if m != nil {
+		m.activeTransfers.add(1, direction)
+	}
+}
+
+func (m *metrics) transferFinished(direction string, seconds float64, err error) {
+	if m == nil {
+		return
+	}
+
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+
+	m.activeTransfers.add(-1, direction)
+	m.transfers.add(1, direction, result)
+	m.durations.observe(seconds, direction)
+}
+
+func (m *metrics) failed(err error) {
+	if m != nil {
+		m.errors.add(1, errorCode(err))
+	}
+}
+
+// errorCode classifies the error that ended a session
+func errorCode(err error) string {
+	var netErr net.Error
+	switch {
+	case err == errServerBusy || err == errUserBusy:
+		return "busy"
+	case err == errCancelled || err == errClientCancelled || err == errShuttingDown:
+		return "cancelled"
+	case errors.Is(err, errUnauthorized):
+		return "unauthorized"
+	case errors.Is(err, common.ErrVersionMismatch):
+		return "version_mismatch"
+	case errors.Is(err, common.ErrPathNotAllowed):
+		return "path_not_allowed"
+	case common.ResponseCode(err) == wire.DiskFull:
+		return "disk_full"
+	case common.ResponseCode(err) == wire.QuotaExceeded:
+		return "quota_exceeded"
+	case errors.Is(err, os.ErrNotExist):
+		return "not_found"
+	case errors.Is(err, os.ErrPermission):
+		return "permission_denied"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		return "disconnected"
+	}
+
+	return "error"
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.write(w)
+}
+
+func (m *metrics) write(w io.Writer) {
+	for _, vec := range []*metricVec{m.sessions, m.authFailures, m.bytesReceived, m.bytesSent, m.activeTransfers, m.transfers, m.errors} {
+		vec.write(w)
+	}
+	m.durations.write(w)
+}
+
+// serveMetrics serves /metrics on address until the listener is closed
+func serveMetrics(address string, m *metrics) (listener net.Listener, e error) {
+	if listener, e = net.Listen("tcp", address); e != nil {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	go http.Serve(listener, mux)
+
+	return
+}
+
+// metricVec is a counter or gauge with a value per combination of labels
+type metricVec struct {
+	name   string
+	kind   string
+	help   string
+	labels []string
+	mutex  sync.Mutex
+	values map[string]float64
+}
+
+func newMetricVec(name, kind, help string, labels ...string) *metricVec {
+	return &metricVec{
+		name:   name,
+		kind:   kind,
+		help:   help,
+		labels: labels,
+		values: make(map[string]float64),
+	}
+}
+
+func (v *metricVec) add(delta float64, labelValues ...string) {
+	key := formatLabels(v.labels, labelValues)
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.values[key] += delta
+}
+
+func (v *metricVec) write(w io.Writer) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, v.help, v.name, v.kind)
+	if len(v.labels) == 0 && len(v.values) == 0 {
+		fmt.Fprintf(w, "%s 0\n", v.name)
+	}
+
+	for _, key := range sortedKeys(v.values) {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, key, formatValue(v.values[key]))
+	}
+}
+
+// histogramVec counts observations in cumulative buckets per combination of
+// labels
+type histogramVec struct {
+	name       string
+	help       string
+	labels     []string
+	buckets    []float64
+	mutex      sync.Mutex
+	histograms map[string]*histogram
+}
+
+type histogram struct {
+	labelValues []string
+	counts      []uint64
+	count       uint64
+	sum         float64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{
+		name:       name,
+		help:       help,
+		labels:     labels,
+		buckets:    buckets,
+		histograms: make(map[string]*histogram),
+	}
+}
+
+func (v *histogramVec) observe(value float64, labelValues ...string) {
+	key := formatLabels(v.labels, labelValues)
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	h, ok := v.histograms[key]
+	if !ok {
+		h = &histogram{
+			labelValues: labelValues,
+			counts:      make([]uint64, len(v.buckets)),
+		}
+		v.histograms[key] = h
+	}
+
+	for i, bound := range v.buckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+func (v *histogramVec) write(w io.Writer) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", v.name, v.help, v.name)
+
+	keys := make([]string, 0, len(v.histograms))
+	for key := range v.histograms {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		h := v.histograms[key]
+		bucketLabels := append(append([]string{}, v.labels...), "le")
+		for i, bound := range v.buckets {
+			values := append(append([]string{}, h.labelValues...), fmt.Sprint(bound))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, formatLabels(bucketLabels, values), h.counts[i])
+		}
+		values := append(append([]string{}, h.labelValues...), "+Inf")
+		fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, formatLabels(bucketLabels, values), h.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", v.name, key, formatValue(h.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", v.name, key, h.count)
+	}
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = fmt.Sprintf("%s=%q", name, value)
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// metricsConn counts the bytes of a session as they are read and written
+type metricsConn struct {
+	net.Conn
+	metrics *metrics
+}
+
+func newMetricsConn(conn net.Conn, m *metrics) net.Conn {
+	if m == nil {
+		return conn
+	}
+
+	return &metricsConn{Conn: conn, metrics: m}
+}
+
+func (c *metricsConn) Read(b []byte) (n int, e error) {
+	n, e = c.Conn.Read(b)
+	c.metrics.bytesReceived.add(float64(n))
+	return
+}
+
+func (c *metricsConn) Write(b []byte) (n int, e error) {
+	n, e = c.Conn.Write(b)
+	c.metrics.bytesSent.add(float64(n))
+	return
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/murphybytes/ucp/common"
+)
+
+func TestMetricsOutput(t *testing.T) {
+	m := newMetrics()
+	m.sessionStarted()
+	m.sessionStarted()
+	m.bytesSent.add(3011188)
+	m.authFailed("PublicKey")
+	m.transferStarted("write")
+	m.transferFinished("write", 0.7, nil)
+	m.transferStarted("read")
+	m.failed(errServerBusy)
+
+	var out bytes.Buffer
+	m.write(&out)
+	text := out.String()
+
+	expected := []string{
+		"# TYPE ucp_sessions_total counter",
+		"ucp_sessions_total 2",
+		`ucp_auth_failures_total{method="PublicKey"} 1`,
+		"ucp_bytes_received_total 0",
+		"ucp_bytes_sent_total 3011188",
+		`ucp_active_transfers{direction="read"} 1`,
+		`ucp_active_transfers{direction="write"} 0`,
+		`ucp_transfers_total{direction="write",result="ok"} 1`,
+		`ucp_errors_total{code="busy"} 1`,
+		"# TYPE ucp_transfer_duration_seconds histogram",
+		`ucp_transfer_duration_seconds_bucket{direction="write",le="0.5"} 0`,
+		`ucp_transfer_duration_seconds_bucket{direction="write",le="1"} 1`,
+		`ucp_transfer_duration_seconds_bucket{direction="write",le="+Inf"} 1`,
+		`ucp_transfer_duration_seconds_sum{direction="write"} 0.7`,
+		`ucp_transfer_duration_seconds_count{direction="write"} 1`,
+	}
+
+	for _, line := range expected {
+		if !strings.Contains(text, line+"\n") {
+			t.Error("Expected line ", line, " in\n", text)
+		}
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	_, notFound := os.Open("/does/not/exist")
+
+	codes := map[error]string{
+		errUserBusy: "busy",
+		fmt.Errorf("%w for alice", errUnauthorized): "unauthorized",
+		notFound:                "not_found",
+		errors.New("disk full"): "error",
+		&os.PathError{Op: "write", Path: "/data/file", Err: syscall.ENOSPC}: "disk_full",
+		fmt.Errorf("%w - Only /data is served", common.ErrPathNotAllowed):   "path_not_allowed",
+	}
+
+	for err, code := range codes {
+		if errorCode(err) != code {
+			t.Error("Expected ", code, " for ", err, " got ", errorCode(err))
+		}
+	}
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *metrics
+	m.sessionStarted()
+	m.authFailed("PublicKey")
+	m.transferStarted("read")
+	m.transferFinished("read", 1, nil)
+	m.failed(errServerBusy)
+}
@@ -7,15 +7,24 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"math/big"
 	"os/user"
 
 	"github.com/murphybytes/ucp/common"
+	"github.com/murphybytes/ucp/remote"
+	"github.com/murphybytes/ucp/storage"
 	"github.com/murphybytes/ucp/wire"
 )
 
+var (
+	errUnauthorized    = errors.New("Public key is not authorized")
+	errPushUnsupported = errors.New("Pushing is only supported from local storage")
+)
+
 type respondent interface {
 	initializeSecureChannel() (e error)
 	initializeTransfer() (e error)
@@ -25,15 +34,18 @@ type respondent interface {
 }
 
 type client struct {
+	// logger of the session, each transfer adds its path and direction
+	logger       common.Logger
 	clientKey    *rsa.PublicKey
 	serverKey    *rsa.PrivateKey
-	context      *context
+	context      *sessionContext
 	transferInfo *wire.FileTransferRequest
 	aesKey       cipher.Block
 	startingIV   []byte
+	compression  wire.Compression
 }
 
-func newClient(ctx *context) (r respondent, e error) {
+func newClient(ctx *sessionContext) (r respondent, e error) {
 
 	r = &client{
 
@@ -47,36 +59,237 @@ func newClient(ctx *context) (r respondent, e error) {
 func (c *client) getTransferOperation() func() (e error) {
 	return func() (e error) {
 		txfrContext := &transferContext{
+			ctx:                  c.context.ctx,
 			block:                c.aesKey,
 			initializationVector: c.startingIV,
-			conn:                 c.context.conn,
+			conn:                 common.NewThrottledConn(c.context.conn, c.context.throttles...),
+			compression:          c.compression,
+		}
+
+		options := c.transferInfo.Options
+
+		// the session acts as the user it authenticated as and nobody else
+		if c.transferInfo.UserName != c.context.userName {
+			return c.reject(txfrContext, fmt.Errorf("%w, the session is authenticated as %s and can't act as %s",
+				common.ErrPermissionDenied, c.context.userName, c.transferInfo.UserName))
 		}
 
+		if e = c.authorized(); e != nil {
+			return c.reject(txfrContext, e)
+		}
+
+		fs := c.context.storage
+
 		if c.transferInfo.Transfer == wire.ClientWriting {
-			var outFile io.WriteCloser
-			if outFile, e = common.Create(c.transferInfo.FilePath, c.transferInfo.UserName); e != nil {
-				return
+			var outFile storage.PendingFile
+			if outFile, e = fs.Create(c.context.userName, c.transferInfo.FilePath, options.Has(wire.CreateDirectories)); e != nil {
+				return c.reject(txfrContext, e)
 			}
+			// removes the temporary file if the transfer did not complete
 			defer outFile.Close()
-			return readRemoteWriteLocal(txfrContext, outFile)
+
+			if options.Has(wire.DeltaTransfer) {
+				var signatures []wire.BlockSignature
+				// without an existing file the client sends every byte
+				if basis, err := fs.Open(c.context.userName, c.transferInfo.FilePath); err == nil {
+					defer basis.Close()
+					if signatures, e = common.ComputeSignatures(basis, wire.DeltaBlockSize); e != nil {
+						return c.reject(txfrContext, e)
+					}
+					txfrContext.basis = basis
+					txfrContext.blocks = len(signatures)
+				}
+
+				txfrContext.blockSize = wire.DeltaBlockSize
+				if e = sendSignatures(txfrContext, signatures); e != nil {
+					return
+				}
+			}
+
+			return receiveFile(txfrContext, outFile, c.context.digest, c.context.userName, options)
 		}
 
-		if c.transferInfo.Transfer == wire.ClientWriting {
-			var inFile io.ReadCloser
-			if inFile, e = common.Open(c.transferInfo.FilePath, c.transferInfo.UserName); e != nil {
+		if c.transferInfo.Transfer == wire.ClientReading {
+			var file storage.File
+			if file, e = fs.Open(c.context.userName, c.transferInfo.FilePath); e != nil {
+				return c.reject(txfrContext, e)
+			}
+			defer file.Close()
+
+			var inFile io.Reader = file
+			if offset := c.transferInfo.Offset; offset > 0 {
+				// a client that lost its connection reads the rest
+				inFile = io.NewSectionReader(file, offset, math.MaxInt64-offset)
+			}
+			inFile = io.TeeReader(inFile, c.context.digest)
+
+			if options.Has(wire.DeltaTransfer) {
+				var signatures []wire.BlockSignature
+				var blockSize int
+				if signatures, blockSize, e = receiveSignatures(txfrContext); e != nil {
+					return
+				}
+				txfrContext.delta = common.NewDeltaEncoder(inFile, signatures, blockSize)
+			}
+
+			if e = readLocalWriteRemote(txfrContext, inFile); e != nil {
+				return
+			}
+
+			if options.Has(wire.PreserveMetadata) {
+				return sendMetadata(txfrContext, file, options)
+			}
+
+			return nil
+		}
+
+		if c.transferInfo.Transfer == wire.ClientListing || c.transferInfo.Transfer == wire.ClientStating {
+			entries, err := c.listPath()
+			return sendListing(txfrContext, entries, err)
+		}
+
+		if c.transferInfo.Transfer == wire.ClientPushing {
+			// the server connects on the client's behalf only once it knows
+			// the client holds the session key
+			if e = receiveKeyConfirmation(txfrContext); e != nil {
+				return
+			}
+
+			err := c.push()
+			if e = sendClientReadResponse(txfrContext, err); e != nil {
+				return
+			}
+
+			return reported(err)
+		}
+
+		switch c.transferInfo.Transfer {
+		case wire.ClientRemoving, wire.ClientMakingDirectory, wire.ClientMoving:
+			if e = receiveKeyConfirmation(txfrContext); e != nil {
+				return
+			}
+
+			err := c.changeFiles()
+			if e = sendClientReadResponse(txfrContext, err); e != nil {
 				return
 			}
-			defer inFile.Close()
-			return readLocalWriteRemote(txfrContext, inFile)
+
+			return reported(err)
 		}
 
 		return nil
 	}
 }
 
-// first message from client is unencrypted and contains their public key
+// changeFiles performs a ClientRemoving, ClientMakingDirectory or ClientMoving
+// transfer
+func (c *client) changeFiles() error {
+	fs, userName, path := c.context.storage, c.context.userName, c.transferInfo.FilePath
+	switch c.transferInfo.Transfer {
+	case wire.ClientMakingDirectory:
+		return fs.Mkdir(userName, path, c.transferInfo.Options.Has(wire.CreateDirectories))
+	case wire.ClientMoving:
+		return fs.Rename(userName, path, c.transferInfo.NewPath)
+	}
+
+	return fs.Remove(userName, path)
+}
+
+// authorized asks the authorization hook whether the requested transfer may
+// go ahead.  Rejections are reported as common.ErrPathNotAllowed unless they
+// wrap an error with a code of its own
+func (c *client) authorized() error {
+	if c.context.authorize == nil {
+		return nil
+	}
+
+	request := &Request{
+		User:        c.context.userName,
+		Remote:      c.context.conn.RemoteAddr().String(),
+		Path:        c.transferInfo.FilePath,
+		Transfer:    c.transferInfo.Transfer,
+		Destination: c.transferInfo.Destination,
+		NewPath:     c.transferInfo.NewPath,
+	}
+
+	err := c.context.authorize(request)
+	// a move writes its new path, hooks that only look at Path check it too
+	if err == nil && c.transferInfo.Transfer == wire.ClientMoving {
+		request.Path = c.transferInfo.NewPath
+		err = c.context.authorize(request)
+	}
+
+	if err != nil && common.ResponseCode(err) == wire.Error {
+		err = fmt.Errorf("%w - %s", common.ErrPathNotAllowed, err.Error())
+	}
+
+	return err
+}
+
+// reject tells the client the requested transfer failed with err before
+// anything was transferred, in the reply the client waits for first
+func (c *client) reject(ctx *transferContext, err error) (e error) {
+	switch c.transferInfo.Transfer {
+	case wire.ClientWriting:
+		// an empty file's metadata follows the end of the file
+		return rejectTransfer(ctx, c.transferInfo.Options.Has(wire.PreserveMetadata), err)
+	case wire.ClientReading, wire.ClientPushing, wire.ClientRemoving, wire.ClientMakingDirectory, wire.ClientMoving:
+		return rejectTransfer(ctx, false, err)
+	case wire.ClientListing, wire.ClientStating:
+		return sendListing(ctx, nil, err)
+	}
+
+	if e = sendClientReadResponse(ctx, err); e != nil {
+		return
+	}
+
+	return reported(err)
+}
+
+// listPath returns the listing a ClientListing or ClientStating transfer asked
+// for
+func (c *client) listPath() ([]wire.FileEntry, error) {
+	fs, userName, path := c.context.storage, c.context.userName, c.transferInfo.FilePath
+	checksums := c.transferInfo.Options.Has(wire.ListChecksums)
+	if c.transferInfo.Transfer == wire.ClientStating {
+		entry, e := storage.StatEntry(fs, userName, path, checksums)
+		return []wire.FileEntry{entry}, e
+	}
+
+	if c.transferInfo.Options.Has(wire.ListShallow) {
+		return storage.ListDir(fs, userName, path, checksums)
+	}
+
+	return storage.ListTree(fs, userName, path, checksums)
+}
+
+// push sends the requested file to the destination server.  It connects as a
+// client with the key pair of the authenticated user
+func (c *client) push() (e error) {
+	// the file is read from the local file system
+	if _, ok := c.context.storage.(storage.Local); !ok {
+		return errPushUnsupported
+	}
+
+	var path, keyPath string
+	if path, e = common.UserPath(c.transferInfo.FilePath, c.context.userName); e != nil {
+		return
+	}
+
+	if keyPath, e = getUserKeyPath(c.context.userName); e != nil {
+		return
+	}
+
+	c.context.logger.LogInfo("Pushing to ", c.transferInfo.Destination)
+
+	return remote.Push(c.context.ctx, path, c.transferInfo.Destination, keyPath, c.transferInfo.Options, c.compression, c.context.flags.Limit)
+}
+
+// first message from client is unencrypted and contains their public key.
+// The client then signs a challenge to prove it holds the private key and is
+// told whether it may connect as the user it asked for
 func (c *client) initializeSecureChannel() (e error) {
-	c.context.logger.LogInfo("Beginning public key exchange with client")
+	c.context.logger.LogDebug("Beginning public key exchange with client")
 	networkReadBuff := make([]byte, wire.ReadBufferSize)
 	var readBytes int
 
@@ -97,46 +310,154 @@ func (c *client) initializeSecureChannel() (e error) {
 		return
 	}
 
-	c.context.logger.LogInfo("Successfully received authRequest for ", authRequest.UserName)
+	c.logger = c.context.logger.With("user", authRequest.UserName)
+	c.context.logger = c.logger
+	c.context.logger.LogDebug("Received authentication request")
 
-	if c.serverKey, e = getUserPrivateKey(authRequest.UserName); e != nil {
-		return
+	if c.serverKey = c.context.privateKey; c.serverKey == nil {
+		if c.serverKey, e = getUserPrivateKey(authRequest.UserName); e != nil {
+			c.context.metrics.authFailed(authRequest.RequestedAuthenticationMethod)
+			return
+		}
 	}
 
-	// TODO: check authorization here
-
 	c.clientKey = &authRequest.PublicKey
 
+	c.context.session.setUser(authRequest.UserName, common.PublicKeyFingerprint(c.clientKey))
+
+	var err error
+	if authRequest.ProtocolVersion != wire.ProtocolVersion {
+		err = fmt.Errorf("%w, the client speaks version %d and the server version %d, use the same release of ucp on both",
+			common.ErrVersionMismatch, authRequest.ProtocolVersion, wire.ProtocolVersion)
+	}
+
+	// the client proves it holds the private key by signing the challenge
+	challenge := make([]byte, wire.ChallengeSize)
+	if _, e = rand.Read(challenge); e != nil {
+		return
+	}
+
 	// we now have clients public key, so send server public key to client
 
 	authResponse := wire.AutenticationResponse{
 		UserName:                    authRequest.UserName,
 		PublicKey:                   c.serverKey.PublicKey,
 		AllowedAuthenticationMethod: authRequest.RequestedAuthenticationMethod,
+		Status:                      wire.OK,
+		StatusText:                  "OK",
+		Challenge:                   challenge,
+	}
+
+	if err != nil {
+		authResponse.Status, authResponse.StatusText = authStatus(err)
+	}
+
+	if e = c.sendPlain(authResponse); e != nil {
+		return
+	}
+
+	if err != nil {
+		return err
+	}
+
+	c.context.logger.LogDebug("Sent our public key to client")
+
+	var proof wire.AuthenticationProof
+	if e = c.receivePlain(&proof); e != nil {
+		return
+	}
+
+	if err = common.VerifyChallenge(c.clientKey, challenge, authRequest.UserName, proof.Signature); err != nil {
+		err = fmt.Errorf("%w, the client does not hold the private key of the key it sent", errUnauthorized)
+	}
+
+	if err == nil {
+		var authorized bool
+		authorized, err = c.context.authenticator.Authenticate(authRequest.UserName, c.clientKey)
+		if err == nil && !authorized {
+			err = fmt.Errorf("%w for %s, add the key to ~/.ucp/authorized_keys of the user on the server", errUnauthorized, authRequest.UserName)
+		}
+	}
+
+	if err != nil {
+		c.context.metrics.authFailed(authRequest.RequestedAuthenticationMethod)
+	}
+
+	if err == nil {
+		// limits and throttles count the user the client proved to be
+		var releaseSession func()
+		if releaseSession, err = c.context.limits.acquireUser(authRequest.UserName); err == nil {
+			var releaseThrottles func()
+			c.context.throttles, releaseThrottles = c.context.bandwidth.acquire(authRequest.UserName)
+			c.context.release = func() {
+				releaseThrottles()
+				releaseSession()
+			}
+		}
+	}
+
+	result := wire.AuthenticationResult{
 		Status:     wire.OK,
 		StatusText: "OK",
 	}
 
-	encoderBuffer.Reset()
-	encoder := gob.NewEncoder(encoderBuffer)
+	if err != nil {
+		result.Status, result.StatusText = authStatus(err)
+	}
 
-	if e = encoder.Encode(authResponse); e != nil {
+	if e = c.sendPlain(result); e != nil {
 		return
 	}
 
-	if _, e = c.context.conn.Write(encoderBuffer.Bytes()); e != nil {
-		return
+	if err != nil {
+		return err
 	}
 
-	c.context.logger.LogInfo("Sent our public key to client")
+	// every transfer of the session acts as this user
+	c.context.userName = authRequest.UserName
+	c.context.logger.LogDebug("Client authenticated")
 
 	return
 }
 
+// authStatus returns the status that reports a failed authentication
+func authStatus(err error) (status wire.ResponseCode, statusText string) {
+	status, statusText = common.ResponseCode(err), err.Error()
+	if err == errServerBusy || err == errUserBusy {
+		status = wire.Busy
+	} else if status == wire.Error {
+		status = wire.AuthFailed
+	}
+
+	return
+}
+
+// sendPlain sends an unencrypted message of the handshake
+func (c *client) sendPlain(msg interface{}) (e error) {
+	var buffer bytes.Buffer
+	if e = gob.NewEncoder(&buffer).Encode(msg); e != nil {
+		return
+	}
+
+	_, e = c.context.conn.Write(buffer.Bytes())
+	return
+}
+
+// receivePlain reads an unencrypted message of the handshake into msg
+func (c *client) receivePlain(msg interface{}) (e error) {
+	buffer := make([]byte, wire.ReadBufferSize)
+	var read int
+	if read, e = c.context.conn.Read(buffer); e != nil {
+		return
+	}
+
+	return gob.NewDecoder(bytes.NewBuffer(buffer[:read])).Decode(msg)
+}
+
 /////////////////////////////////////////////////
 // exchange keys
 func (c *client) initializeTransfer() (e error) {
-	c.context.logger.LogInfo("Preparing for file transfer")
+	c.context.logger.LogDebug("Preparing for file transfer")
 
 	var clientMsg []byte
 	if clientMsg, e = c.getMessage(); e != nil {
@@ -152,7 +473,20 @@ func (c *client) initializeTransfer() (e error) {
 		return
 	}
 
-	c.context.logger.LogInfo("Recieved trasfer message preparing AES key")
+	switch c.transferInfo.Compression {
+	case wire.GzipCompression, wire.AutoCompression:
+		c.compression = c.transferInfo.Compression
+	default:
+		c.compression = wire.NoCompression
+	}
+
+	c.context.record, c.context.digest = c.context.session.startTransfer(c.transferInfo.FilePath, c.transferInfo.Transfer.String())
+	c.context.record.NewPath = c.transferInfo.NewPath
+	if c.transferInfo.UserName != c.context.userName {
+		c.context.record.RequestedUser = c.transferInfo.UserName
+	}
+	c.context.logger = c.logger.With("path", c.transferInfo.FilePath, "direction", c.transferInfo.Transfer.String())
+	c.context.logger.LogInfo("Starting transfer")
 	// generate random key and initialization vector for aes-256
 	keylen := 32
 	keybuff := make([]byte, keylen)
@@ -174,6 +508,8 @@ func (c *client) initializeTransfer() (e error) {
 		StatusText:           "OK",
 		AESKey:               keybuff,
 		InitializationVector: c.startingIV,
+		Compression:          c.compression,
+		FileSize:             c.fileSize(),
 	}
 
 	var encodeBuff bytes.Buffer
@@ -186,12 +522,27 @@ func (c *client) initializeTransfer() (e error) {
 		return
 	}
 
-	c.context.logger.LogInfo("Sent AES key to client")
+	c.context.logger.LogDebug("Sent AES key to client")
 
 	return
 
 }
 
+// fileSize returns the size of the file the client is about to read so it can
+// report progress, -1 if it is unknown
+func (c *client) fileSize() int64 {
+	if c.transferInfo.Transfer != wire.ClientReading {
+		return -1
+	}
+
+	info, e := c.context.storage.Stat(c.context.userName, c.transferInfo.FilePath)
+	if e != nil || !info.Mode().IsRegular() {
+		return -1
+	}
+
+	return info.Size()
+}
+
 func (c *client) getMessage() (msg []byte, e error) {
 	buffer := make([]byte, wire.ReadBufferSize)
 	var read int
@@ -220,15 +571,22 @@ func (c *client) sendMessage(msg []byte) (e error) {
 }
 
 func getUserPrivateKey(userName string) (key *rsa.PrivateKey, e error) {
-	var u *user.User
-	if u, e = user.Lookup(userName); e != nil {
+	var privateKeyPath string
+	if privateKeyPath, e = getUserKeyPath(userName); e != nil {
 		return
 	}
 
-	privateKeyPath := fmt.Sprint(u.HomeDir, "/.ucp/ucp.pem")
-
 	key, e = common.GetPrivateKey(privateKeyPath)
 
 	return
 
 }
+
+func getUserKeyPath(userName string) (path string, e error) {
+	var u *user.User
+	if u, e = user.Lookup(userName); e != nil {
+		return
+	}
+
+	return fmt.Sprint(u.HomeDir, "/.ucp/ucp.pem"), nil
+}
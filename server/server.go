@@ -1,86 +1,534 @@
 package server
 
 import (
+	"context"
+	"crypto/rsa"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/murphybytes/ucp/common"
+	"github.com/murphybytes/ucp/storage"
+	"github.com/murphybytes/ucp/wire"
 	"github.com/murphybytes/udt.go/udt"
 )
 
+var errShuttingDown = errors.New("Server is shutting down")
+
 // Server contains all the logic involved with handling incoming client
 // connections
 type Server struct {
-	flags *common.Flags
+	// commandLine are the flags the server was created with, flags are them
+	// updated by the configuration file
+	commandLine *common.Flags
+	flags       *common.Flags
+	// pluggable parts of the server, see Options
+	authenticator common.Authenticator
+	authorize     func(*Request) error
+	storage       storage.FS
+	privateKey    *rsa.PrivateKey
+	customLogger  common.Logger
+	// settings that change on reload, sessions keep the ones they started with
+	mutex     sync.Mutex
+	logger    common.Logger
+	bandwidth *bandwidth
+	keys      *authorizedKeys
+	limits    *sessionLimits
+	audit     *auditLog
+	metrics   *metrics
+	// sessions in progress, ctx is cancelled when the shutdown timeout passes
+	ctx      context.Context
+	stop     context.CancelCauseFunc
+	running  sync.WaitGroup
+	sessions map[int64]*session
+	listener net.Listener
+	stopping bool
+}
+
+// Options configure a Server created with NewServer
+type Options struct {
+	// Flags hold the limits, timeouts, logging and the other settings of the
+	// server.  Run listens on their host and port, Serve applies the
+	// configuration file they name
+	Flags *common.Flags
+	// Logger receives the server's log lines, a logger configured by Flags if
+	// nil
+	Logger common.Logger
+	// Authenticator decides which keys may connect as which users, the
+	// authorized_keys files of the users if nil
+	Authenticator common.Authenticator
+	// Authorize is called before every transfer and rejects it by returning
+	// an error, every transfer is allowed if nil.  Moves are authorized once
+	// with Path set to each of their paths.  Clients are told the path
+	// is not allowed unless the error wraps one of the errors of common
+	Authorize func(r *Request) error
+	// Storage holds the files clients read and write, storage.Local if nil
+	Storage storage.FS
+	// PrivateKey is used for every user instead of the ucp.pem key in the
+	// home directory of the user
+	PrivateKey *rsa.PrivateKey
+}
+
+// Request describes a transfer a client asked for
+type Request struct {
+	// User is the user the client authenticated as, the only one a session
+	// acts as
+	User string
+	// Remote is the address of the client
+	Remote   string
+	Path     string
+	Transfer wire.TransferType
+	// Destination of a ClientPushing transfer
+	Destination string
+	// NewPath of a ClientMoving transfer
+	NewPath string
 }
 
 // New creates a Server
 func New(flags *common.Flags) common.Application {
-	return &Server{
-		flags: flags,
+	opts := Options{Flags: flags}
+	if flags.S3Bucket != "" {
+		opts.Storage = &storage.S3{
+			Endpoint:     flags.S3Endpoint,
+			Bucket:       flags.S3Bucket,
+			Region:       flags.S3Region,
+			AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		}
 	}
+
+	return NewServer(opts)
+}
+
+// NewServer creates a Server that can be embedded in other programs
+func NewServer(opts Options) *Server {
+	s := &Server{
+		flags:         opts.Flags,
+		authenticator: opts.Authenticator,
+		authorize:     opts.Authorize,
+		storage:       opts.Storage,
+		privateKey:    opts.PrivateKey,
+		customLogger:  opts.Logger,
+		sessions:      make(map[int64]*session),
+	}
+	s.ctx, s.stop = context.WithCancelCause(context.Background())
+
+	if s.flags == nil {
+		s.flags = &common.Flags{}
+	}
+	s.commandLine = s.flags
+
+	if s.storage == nil {
+		s.storage = storage.Local{}
+	}
+
+	return s
 }
 
 func getServerString(flags *common.Flags) string {
 	return fmt.Sprintf("%s:%d", flags.Host, flags.Port)
 }
 
-// Run the application as a server
+// Run the application as a server.  SIGTERM and SIGINT stop the server once
+// the transfers in progress finish, SIGHUP rereads the configuration file and
+// authorized keys
 func (s *Server) Run() (e error) {
-	var logger common.Logger
-	if logger, e = common.NewLogger(s.flags); e != nil {
-		return
-	}
-
 	var listener net.Listener
 	connectString := getServerString(s.flags)
-	logger.LogInfo("Connecting to ", connectString)
 	if listener, e = udt.Listen(connectString); e != nil {
 		return
 	}
 
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	go s.handleSignals(signals)
+
+	return s.Serve(listener)
+}
+
+// Serve accepts connections on listener until Shutdown is called or the
+// listener fails.  It returns once the transfers in progress finish
+func (s *Server) Serve(listener net.Listener) (e error) {
+	var flags *common.Flags
+	if flags, e = s.configure(); e != nil {
+		listener.Close()
+		return
+	}
+
+	var logger common.Logger
+	if logger, e = s.newLogger(flags); e != nil {
+		listener.Close()
+		return
+	}
+
+	s.mutex.Lock()
+	s.flags = flags
+	s.logger = logger
+	s.bandwidth = newBandwidth(s.flags)
+	s.keys = newAuthorizedKeys()
+	s.limits = newSessionLimits(s.flags.MaxSessions, s.flags.MaxUserSessions)
+	s.listener = listener
+	stopping := s.stopping
+	s.mutex.Unlock()
+
 	defer listener.Close()
+	if stopping {
+		return nil
+	}
+
+	if s.audit, e = openAuditLog(s.flags.AuditLog); e != nil {
+		return
+	}
+	defer s.audit.close()
+
+	if s.flags.AdminSocket != "" {
+		var adminListener net.Listener
+		if adminListener, e = s.serveAdmin(s.flags.AdminSocket); e != nil {
+			return
+		}
+		defer adminListener.Close()
+	}
+
+	if s.flags.MetricsAddress != "" {
+		s.metrics = newMetrics()
+		var metricsListener net.Listener
+		if metricsListener, e = serveMetrics(s.flags.MetricsAddress, s.metrics); e != nil {
+			return
+		}
+		defer metricsListener.Close()
+		logger.LogInfo("Serving metrics on ", metricsListener.Addr())
+	}
+
+	logger.LogInfo("Listening on ", listener.Addr())
 
 	for connectionCount := int64(1); ; connectionCount++ {
 		var conn net.Conn
 		conn, e = listener.Accept()
 
-		if e == nil {
-
-			go handleConnection(context{
-				flags:  s.flags,
-				conn:   conn,
-				logger: logger,
-				connID: connectionCount,
-			})
-
-		} else {
-			logger.LogError(e.Error())
+		if e != nil {
+			if s.isStopping() {
+				e = nil
+			} else {
+				s.currentLogger().LogError(e.Error())
+			}
 			break
 		}
+
+		// the slot is taken before a goroutine handles the connection, so
+		// clients that don't authenticate can't pile up
+		release, err := s.limits.acquire()
+		if err != nil {
+			s.currentLogger().LogWarn("Closing connection from ", conn.RemoteAddr().String(), " - ", err.Error())
+			s.metrics.failed(err)
+			conn.Close()
+			continue
+		}
+
+		s.startSession(connectionCount, conn, release)
 	}
 
+	s.drain()
+
 	return
 }
 
-func handleConnection(ctx context) {
+// Shutdown stops accepting connections.  Serve returns once the transfers in
+// progress finish, or after the shutdown timeout
+func (s *Server) Shutdown() {
+	s.mutex.Lock()
+	s.stopping = true
+	listener := s.listener
+	s.mutex.Unlock()
+
+	if listener != nil {
+		listener.Close()
+	}
+}
+
+func (s *Server) handleSignals(signals chan os.Signal) {
+	for sig := range signals {
+		if sig == syscall.SIGHUP {
+			s.reload()
+			continue
+		}
+
+		if logger := s.currentLogger(); logger != nil {
+			logger.LogInfo("Received ", sig, ", waiting for transfers in progress")
+		}
+		s.Shutdown()
+		return
+	}
+}
+
+// reload rereads the configuration file and forgets cached authorized keys.
+// Settings removed from the file return to their command line values.  The
+// bandwidth limits apply to sessions in progress, their other settings are
+// the ones they started with
+func (s *Server) reload() {
+	flags, e := s.configure()
+	if e != nil {
+		s.currentLogger().LogError("Reload failed - ", e.Error())
+		return
+	}
+
+	logger, e := s.newLogger(flags)
+	if e != nil {
+		s.currentLogger().LogError("Reload failed - ", e.Error())
+		return
+	}
+
+	s.mutex.Lock()
+	s.flags = flags
+	s.logger = logger
+	s.bandwidth.setLimits(flags)
+	s.keys = newAuthorizedKeys()
+	s.limits.setLimits(flags.MaxSessions, flags.MaxUserSessions)
+	s.mutex.Unlock()
+
+	logger.LogInfo("Reloaded configuration")
+}
+
+// configure returns the flags the server was created with updated by the
+// configuration file they name.  Errors don't wrap the error reading the file
+// so a missing file isn't reported like a missing remote file
+func (s *Server) configure() (flags *common.Flags, e error) {
+	configured := *s.commandLine
+	if configured.Config != "" {
+		if e = common.LoadConfig(configured.Config, &configured); e != nil {
+			return nil, fmt.Errorf("Loading configuration failed - %s", e.Error())
+		}
+	}
+
+	return &configured, nil
+}
+
+func (s *Server) newLogger(flags *common.Flags) (common.Logger, error) {
+	if s.customLogger != nil {
+		return s.customLogger, nil
+	}
+
+	return common.NewLogger(flags)
+}
+
+// startSession handles conn in a goroutine of its own.  release is called
+// once the session ends
+func (s *Server) startSession(connID int64, conn net.Conn, release func()) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sess := newSession(s.ctx, connID, conn)
+	s.sessions[connID] = sess
+	s.running.Add(1)
+
+	authenticator := s.authenticator
+	if authenticator == nil {
+		authenticator = s.keys
+	}
+
+	ctx := sessionContext{
+		ctx:           sess.ctx,
+		flags:         s.flags,
+		conn:          conn,
+		logger:        s.logger,
+		connID:        connID,
+		bandwidth:     s.bandwidth,
+		authenticator: authenticator,
+		authorize:     s.authorize,
+		storage:       s.storage,
+		privateKey:    s.privateKey,
+		limits:        s.limits,
+		audit:         s.audit,
+		metrics:       s.metrics,
+		session:       sess,
+		record:        sess.record,
+		digest:        sess.digest,
+	}
+
+	go func() {
+		defer release()
+		defer s.endSession(connID)
+		handleConnection(ctx)
+	}()
+}
+
+func (s *Server) endSession(connID int64) {
+	s.mutex.Lock()
+	s.sessions[connID].stop(nil)
+	delete(s.sessions, connID)
+	s.mutex.Unlock()
+	s.running.Done()
+}
+
+// drain waits for sessions in progress.  Sessions still running after the
+// shutdown timeout are cancelled
+func (s *Server) drain() {
+	done := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return
+	case <-time.After(s.currentFlags().ShutdownTimeout):
+	}
+
+	s.mutex.Lock()
+	s.logger.LogWarn("Cancelling ", len(s.sessions), " transfers that did not finish in time")
+	s.mutex.Unlock()
+	s.stop(errShuttingDown)
+
+	<-done
+}
+
+func (s *Server) isStopping() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.stopping
+}
+
+func (s *Server) currentFlags() *common.Flags {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.flags
+}
+
+func (s *Server) currentLogger() common.Logger {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.logger
+}
+
+func handleConnection(ctx sessionContext) {
 	defer ctx.conn.Close()
-	ctx.logger.LogInfo("Connection from ", ctx.conn.RemoteAddr())
+	defer common.CloseOnCancel(ctx.ctx, ctx.conn, common.CancelGracePeriod)()
+	ctx.logger = ctx.logger.With("session", ctx.connID, "remote", ctx.conn.RemoteAddr().String())
+	ctx.logger.LogInfo("Connection opened")
+	ctx.metrics.sessionStarted()
+	ctx.conn = newMetricsConn(ctx.conn, ctx.metrics)
 
+	defer func() {
+		if ctx.release != nil {
+			ctx.release()
+		}
+	}()
+
+	// failures outside of a transfer, transfers record their own
 	var e error
+	defer func() {
+		if e != nil {
+			ctx.metrics.failed(e)
+			writeAuditRecord(&ctx, e)
+		}
+	}()
+	if ctx.flags.HandshakeTimeout > 0 {
+		if e = ctx.conn.SetDeadline(time.Now().Add(ctx.flags.HandshakeTimeout)); e != nil {
+			ctx.logger.LogError("Setting handshake deadline failed -", e.Error())
+			return
+		}
+	}
+
 	var client respondent
 	if client, e = newClient(&ctx); e != nil {
 		ctx.logger.LogError("Client creation failed -", e.Error())
 	}
 
-	if e = authenticate(client); e != nil {
+	if e = client.initializeSecureChannel(); e != nil {
 		ctx.logger.LogError("Authentication failed -", e.Error())
 		return
 	}
 
+	// from now on each read and write extends the deadline
+	if e = ctx.conn.SetDeadline(time.Time{}); e != nil {
+		ctx.logger.LogError("Clearing handshake deadline failed -", e.Error())
+		return
+	}
+	ctx.conn = newIdleConn(ctx.conn, ctx.flags.IdleTimeout)
+	logger := ctx.logger
+
+	// the client sends one request after another until it closes the
+	// connection.  Failures the client was told about leave both sides ready
+	// for the next request
+	for {
+		if e = client.initializeTransfer(); e == io.EOF {
+			e = nil
+			logger.LogInfo("Connection closed")
+			return
+		}
+
+		if e != nil {
+			ctx.logger.LogError("Starting transfer failed - ", e.Error())
+			return
+		}
+
+		err := runTransfer(&ctx, client)
+		if err != nil && !errors.As(err, &reportedError{}) {
+			return
+		}
+	}
+}
+
+// runTransfer performs the transfer the client just requested and records
+// its outcome
+func runTransfer(ctx *sessionContext, client respondent) (e error) {
+	direction := ctx.record.Direction
+	ctx.metrics.transferStarted(direction)
+	started := time.Now()
+
 	transfer := client.getTransferOperation()
-	if e = transfer(); e != nil {
+	e = transfer()
+	if e != nil && ctx.ctx.Err() != nil {
+		e = context.Cause(ctx.ctx)
+	}
+	ctx.metrics.transferFinished(direction, time.Since(started).Seconds(), e)
+	writeAuditRecord(ctx, e)
+	// written, a failure starting the next transfer has no record of its own
+	ctx.record = nil
+
+	if e != nil {
+		ctx.metrics.failed(e)
+		ctx.logger.LogError("Transfer failed - ", e.Error())
+		return
+	}
+
+	ctx.logger.LogInfo("Transfer complete")
+
+	return
+}
+
+// writeAuditRecord completes the audit record of the current transfer with
+// the outcome err and writes it.  Connections that never named a user are not
+// recorded
+func writeAuditRecord(ctx *sessionContext, err error) {
+	record := ctx.record
+	if record == nil || record.User == "" {
 		return
 	}
 
+	record.Duration = time.Since(record.Time).Seconds()
+	record.Bytes = ctx.digest.count()
+	record.Result = "ok"
+	if err != nil {
+		record.Result = "error"
+		record.Error = err.Error()
+	}
+
+	// only reads and writes move file data
+	moved := record.Direction == wire.ClientReading.String() || record.Direction == wire.ClientWriting.String()
+	if moved && (err == nil || record.Bytes > 0) {
+		record.Digest = ctx.digest.sum()
+	}
+
+	if e := ctx.audit.write(record); e != nil {
+		ctx.logger.LogError("Writing audit record failed - ", e.Error())
+	}
 }
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/murphybytes/ucp/common"
+)
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ucp")
+	if err != nil {
+		t.Fatal("Temp dir creation failed -", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	config := filepath.Join(dir, "ucp.conf")
+	if err = ioutil.WriteFile(config, []byte("max-sessions 2\nuser-limit 1M\n"), 0600); err != nil {
+		t.Fatal("Write failed -", err.Error())
+	}
+
+	s := NewServer(Options{Flags: &common.Flags{LogLevel: "ERROR", Config: config, MaxSessions: 5}})
+	if s.flags, err = s.configure(); err != nil {
+		t.Fatal("configure failed -", err.Error())
+	}
+	s.logger, _ = common.NewLogger(s.flags)
+	s.bandwidth = newBandwidth(s.flags)
+	s.limits = newSessionLimits(s.flags.MaxSessions, s.flags.MaxUserSessions)
+
+	if s.flags.MaxSessions != 2 || s.flags.UserLimit != 1<<20 {
+		t.Error("Expected the configuration file to override the command line, got ", s.flags.MaxSessions, " ", s.flags.UserLimit)
+	}
+
+	throttles, release := s.bandwidth.acquire("alice")
+	defer release()
+
+	if err = ioutil.WriteFile(config, []byte("# no settings\n"), 0600); err != nil {
+		t.Fatal("Write failed -", err.Error())
+	}
+	s.reload()
+
+	if flags := s.currentFlags(); flags.MaxSessions != 5 || flags.UserLimit != 0 {
+		t.Error("Expected settings removed from the file to return to their command line values, got ", flags.MaxSessions, " ", flags.UserLimit)
+	}
+
+	reloaded, releaseReloaded := s.bandwidth.acquire("alice")
+	defer releaseReloaded()
+
+	if reloaded[1] != throttles[1] {
+		t.Error("Expected sessions before and after a reload to share the user's throttle")
+	}
+}
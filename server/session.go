@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var errCancelled = errors.New("Session cancelled by an administrator")
+
+// session is a connection in progress as the admin socket sees it.  The
+// session's goroutine fills in its audit record through the methods below so
+// the admin socket can read it at the same time
+type session struct {
+	mutex  sync.Mutex
+	record *auditRecord
+	digest *auditDigest
+	// ctx is done once the session is cancelled or the server gives up on it
+	ctx  context.Context
+	stop context.CancelCauseFunc
+}
+
+// sessionInfo describes a session to admin clients
+type sessionInfo struct {
+	ID        int64     `json:"id"`
+	User      string    `json:"user"`
+	Remote    string    `json:"remote"`
+	Path      string    `json:"path"`
+	Direction string    `json:"direction"`
+	Started   time.Time `json:"started"`
+	Bytes     int64     `json:"bytes"`
+	// Rate is the average bytes per second since the session started
+	Rate float64 `json:"rate"`
+}
+
+func newSession(parent context.Context, connID int64, conn net.Conn) *session {
+	ctx, stop := context.WithCancelCause(parent)
+	return &session{
+		ctx:  ctx,
+		stop: stop,
+		record: &auditRecord{
+			Time:    time.Now(),
+			Session: connID,
+			Remote:  conn.RemoteAddr().String(),
+		},
+		digest: newAuditDigest(),
+	}
+}
+
+func (s *session) setUser(userName, fingerprint string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.record.User = userName
+	s.record.KeyFingerprint = fingerprint
+}
+
+// startTransfer begins the audit record of the session's next transfer and
+// returns it with the digest of the transfer's file data
+func (s *session) startTransfer(path, direction string) (*auditRecord, *auditDigest) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.record = &auditRecord{
+		Time:           time.Now(),
+		Session:        s.record.Session,
+		Remote:         s.record.Remote,
+		User:           s.record.User,
+		KeyFingerprint: s.record.KeyFingerprint,
+		Path:           path,
+		Direction:      direction,
+	}
+	s.digest = newAuditDigest()
+
+	return s.record, s.digest
+}
+
+// cancel fails the session's transfer.  The client is told with the reply to
+// its next message, the connection is closed if that doesn't come in time
+func (s *session) cancel() {
+	s.stop(errCancelled)
+}
+
+func (s *session) isCancelled() bool {
+	return context.Cause(s.ctx) == errCancelled
+}
+
+func (s *session) info() sessionInfo {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	info := sessionInfo{
+		ID:        s.record.Session,
+		User:      s.record.User,
+		Remote:    s.record.Remote,
+		Path:      s.record.Path,
+		Direction: s.record.Direction,
+		Started:   s.record.Time,
+		Bytes:     s.digest.count(),
+	}
+
+	if elapsed := time.Since(info.Started).Seconds(); elapsed > 0 {
+		info.Rate = float64(info.Bytes) / elapsed
+	}
+
+	return info
+}
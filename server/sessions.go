@@ -0,0 +1,115 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	errServerBusy = errors.New("Server is at capacity, try again later")
+	errUserBusy   = errors.New("Too many sessions for this user, try again later")
+)
+
+// sessionLimits counts connections and the sessions of each user, and
+// rejects new ones once the server or the user has too many.  The limits
+// change on reload without losing count of sessions in progress
+type sessionLimits struct {
+	mutex   sync.Mutex
+	max     int
+	maxUser int
+	total   int
+	users   map[string]int
+}
+
+func newSessionLimits(max, maxUser int) *sessionLimits {
+	return &sessionLimits{
+		max:     max,
+		maxUser: maxUser,
+		users:   make(map[string]int),
+	}
+}
+
+func (l *sessionLimits) setLimits(max, maxUser int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.max = max
+	l.maxUser = maxUser
+}
+
+// acquire counts a connection against the limit of the server.  It is called
+// before the connection is handled, so clients that never authenticate can't
+// exceed the limit.  The returned function must be called when the connection
+// closes
+func (l *sessionLimits) acquire() (release func(), e error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.max > 0 && l.total >= l.max {
+		return nil, errServerBusy
+	}
+
+	l.total++
+
+	return func() {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		l.total--
+	}, nil
+}
+
+// acquireUser counts a session of userName once it authenticated.  The
+// returned function must be called when the session ends
+func (l *sessionLimits) acquireUser(userName string) (release func(), e error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.maxUser > 0 && l.users[userName] >= l.maxUser {
+		return nil, errUserBusy
+	}
+
+	l.users[userName]++
+
+	return func() {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+
+		if l.users[userName]--; l.users[userName] == 0 {
+			delete(l.users, userName)
+		}
+	}, nil
+}
+
+// idleConn closes a session whose peer sends or receives nothing for timeout
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func newIdleConn(conn net.Conn, timeout time.Duration) net.Conn {
+	if timeout <= 0 {
+		return conn
+	}
+
+	return &idleConn{
+		Conn:    conn,
+		timeout: timeout,
+	}
+}
+
+func (c *idleConn) Read(b []byte) (n int, e error) {
+	if e = c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); e != nil {
+		return
+	}
+
+	return c.Conn.Read(b)
+}
+
+func (c *idleConn) Write(b []byte) (n int, e error) {
+	if e = c.Conn.SetWriteDeadline(time.Now().Add(c.timeout)); e != nil {
+		return
+	}
+
+	return c.Conn.Write(b)
+}
@@ -0,0 +1,59 @@
+package server
+
+import "testing"
+
+func TestSessionLimits(t *testing.T) {
+	limits := newSessionLimits(3, 2)
+
+	first, err := limits.acquire()
+	if err != nil {
+		t.Fatal("Unexpected error ", err.Error())
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err = limits.acquire(); err != nil {
+			t.Fatal("Unexpected error ", err.Error())
+		}
+	}
+
+	// connections count before they authenticate
+	if _, err = limits.acquire(); err != errServerBusy {
+		t.Error("Expected ", errServerBusy, " got ", err)
+	}
+
+	first()
+	if _, err = limits.acquire(); err != nil {
+		t.Error("Expected a released connection to make room ", err)
+	}
+
+	alice, err := limits.acquireUser("alice")
+	if err != nil {
+		t.Fatal("Unexpected error ", err.Error())
+	}
+
+	if _, err = limits.acquireUser("alice"); err != nil {
+		t.Fatal("Unexpected error ", err.Error())
+	}
+
+	if _, err = limits.acquireUser("alice"); err != errUserBusy {
+		t.Error("Expected ", errUserBusy, " got ", err)
+	}
+
+	if _, err = limits.acquireUser("bob"); err != nil {
+		t.Error("Expected other users not to count against alice ", err)
+	}
+
+	alice()
+	if _, err = limits.acquireUser("alice"); err != nil {
+		t.Error("Expected a released session to make room ", err)
+	}
+
+	limits.setLimits(0, 0)
+	if _, err = limits.acquire(); err != nil {
+		t.Error("Expected no limit ", err)
+	}
+
+	if _, err = limits.acquireUser("alice"); err != nil {
+		t.Error("Expected no user limit ", err)
+	}
+}
@@ -2,45 +2,131 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/gob"
 	"errors"
-	"fmt"
 	"io"
 
 	"github.com/murphybytes/ucp/common"
+	"github.com/murphybytes/ucp/storage"
 	"github.com/murphybytes/ucp/wire"
 )
 
+var (
+	// errClientCancelled ends the session, the client sends nothing more for
+	// the transfer it cancelled
+	errClientCancelled = errors.New("Transfer cancelled by the client")
+	// errKeyNotConfirmed ends a session whose client could not use the AES
+	// key it was sent
+	errKeyNotConfirmed = errors.New("Client did not confirm the session key")
+)
+
 type transferContext struct {
+	// done once the session is cancelled, the client is told in the reply to
+	// its next message
+	ctx                  context.Context
 	block                cipher.Block
 	initializationVector []byte
 	conn                 io.ReadWriteCloser
+	// set when receiving a delta, the existing file that blocks are copied from
+	basis     io.ReaderAt
+	blockSize int
+	// blocks is the number of blocks of basis the client got signatures of
+	blocks int
+	// set when sending a delta
+	delta *common.DeltaEncoder
+	// method used to compress file data
+	compression wire.Compression
+}
+
+// reportedError is an error the client was told about in the reply that ended
+// a transfer.  Both sides are then waiting for the client's next request, so
+// the session goes on
+type reportedError struct {
+	error
+}
+
+func (r reportedError) Unwrap() error {
+	return r.error
+}
+
+// reported marks err as sent to the client, nil stays nil
+func reported(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return reportedError{err}
+}
+
+// rejectTransfer answers the first message of a transfer that failed before
+// any file data was exchanged with err.  An upload of an empty file sends its
+// metadata straight after the end of the file, metadata says to expect it
+func rejectTransfer(ctx *transferContext, metadata bool, err error) (e error) {
+	var read int
+	encrypted := make([]byte, wire.ReadBufferSize)
+	if read, e = ctx.conn.Read(encrypted); e != nil {
+		return
+	}
+
+	// every message the client starts a transfer with has a Status
+	decrypted := common.DecryptAES(ctx.block, ctx.initializationVector, encrypted[:read])
+	var clientRead wire.ClientRead
+	if e = gob.NewDecoder(bytes.NewBuffer(decrypted)).Decode(&clientRead); e != nil {
+		return
+	}
+
+	if clientRead.Status == wire.Canceled {
+		return errClientCancelled
+	}
+
+	if metadata && clientRead.Status == wire.EOF {
+		if _, e = receiveMetadata(ctx); e != nil {
+			return
+		}
+	}
+
+	response := wire.StatusResponse{
+		Status:     common.ResponseCode(err),
+		StatusText: err.Error(),
+	}
+
+	if e = sendEncrypted(ctx, response); e != nil {
+		return
+	}
+
+	return reported(err)
 }
 
 func readRemoteWriteLocal(ctx *transferContext, outfile io.Writer) (e error) {
-	fmt.Println("read remote write local was called. ")
 	for {
 		var read int
 		encrypted := make([]byte, wire.ReadBufferSize)
 		if read, e = ctx.conn.Read(encrypted); e != nil {
 			return
 		}
-		fmt.Printf("read %d from client\n", read)
 
 		decrypted := common.DecryptAES(ctx.block, ctx.initializationVector, encrypted[:read])
-		fmt.Printf("descrypted len %d\n", len(decrypted))
 
 		decoderBuffer := bytes.NewBuffer(decrypted)
 		decoder := gob.NewDecoder(decoderBuffer)
 
 		clientRead := &wire.ClientRead{}
 		if e = decoder.Decode(clientRead); e != nil {
-			fmt.Println("decode error ", e.Error())
 			return
 		}
 
+		if clientRead.Status == wire.Canceled {
+			return errClientCancelled
+		}
+
+		if ctx.ctx.Err() != nil {
+			return cancelTransfer(ctx)
+		}
+
 		if clientRead.Status == wire.EOF {
 			e = io.EOF
 			return
@@ -54,9 +140,22 @@ func readRemoteWriteLocal(ctx *transferContext, outfile io.Writer) (e error) {
 		}
 
 		var err error
-		if _, e = outfile.Write(clientRead.Buffer); e != nil {
+		if len(clientRead.Delta) > 0 {
+			e = common.ApplyDelta(ctx.basis, ctx.blockSize, ctx.blocks, clientRead.Delta, outfile)
+		} else {
+			data := clientRead.Buffer
+			if clientRead.Compressed {
+				data, e = common.DecompressChunk(data)
+			}
+
+			if e == nil {
+				_, e = outfile.Write(data)
+			}
+		}
+
+		if e != nil {
 			// Tell client to stop sending and disconnect
-			response.Status = wire.Error
+			response.Status = common.ResponseCode(e)
 			response.StatusText = e.Error()
 			err = e
 		} else {
@@ -75,7 +174,7 @@ func readRemoteWriteLocal(ctx *transferContext, outfile io.Writer) (e error) {
 
 		if _, e = ctx.conn.Write(encrypted); e != nil || err != nil {
 			if e == nil {
-				e = err
+				e = reported(err)
 			}
 			return
 		}
@@ -107,30 +206,57 @@ func readLocalWriteRemote(ctx *transferContext, infile io.Reader) (e error) {
 			return
 		}
 
+		if clientDataRequest.Status == wire.Canceled {
+			return errClientCancelled
+		}
+
 		if clientDataRequest.Status != wire.More {
 			return errors.New(clientDataRequest.StatusText)
 		}
 
+		if ctx.ctx.Err() != nil {
+			return cancelTransfer(ctx)
+		}
+
 		newIV := make([]byte, common.IVBlockSize)
 		rand.Read(newIV)
 
 		data := make([]byte, wire.DataBufferSize)
+		var ops []wire.DeltaOp
+
+		if ctx.delta != nil {
+			ops, e = ctx.delta.Next()
+		} else {
+			read, e = infile.Read(data)
+		}
 
-		if read, e = infile.Read(data); e != nil {
+		if e != nil {
 			var empty []byte
-			status := wire.Error
+			status := common.ResponseCode(e)
 
 			err := e
 			if e == io.EOF {
 
 				status = wire.EOF
-				e = nil
 			}
 
-			// send message to client to terminate connection
-			sendClientDataResponse(ctx, newIV, empty, status, err.Error())
-			return
+			// send message to client to end the transfer
+			if e = sendClientDataResponse(ctx, newIV, empty, status, err.Error()); e != nil {
+				return
+			}
 
+			if status != wire.EOF {
+				return reported(err)
+			}
+			return nil
+
+		}
+
+		if ctx.delta != nil {
+			if e = sendDeltaResponse(ctx, newIV, ops); e != nil {
+				return
+			}
+			continue
 		}
 
 		if read == 0 {
@@ -145,14 +271,272 @@ func readLocalWriteRemote(ctx *transferContext, infile io.Reader) (e error) {
 
 }
 
+// sendDeltaResponse sends a batch of delta operations in place of file data
+func sendDeltaResponse(ctx *transferContext, iv []byte, ops []wire.DeltaOp) (e error) {
+	response := wire.ClientDataResponse{
+		NextInitializationVector: iv,
+		Delta:                    ops,
+		Status:                   wire.OK,
+		StatusText:               "OK",
+	}
+
+	var encoderBuffer bytes.Buffer
+	encoder := gob.NewEncoder(&encoderBuffer)
+	if e = encoder.Encode(response); e != nil {
+		return
+	}
+
+	encrypted := common.EncryptAES(ctx.block, ctx.initializationVector, encoderBuffer.Bytes())
+	if _, e = ctx.conn.Write(encrypted); e != nil {
+		return
+	}
+
+	ctx.initializationVector = iv
+
+	return
+}
+
+// sendSignatures sends the signatures of the existing file to a client that
+// is about to send us a delta.  The client requests each packet with a
+// ClientDataRequest
+func sendSignatures(ctx *transferContext, signatures []wire.BlockSignature) (e error) {
+	for sent := 0; ; {
+		if e = receiveDataRequest(ctx); e != nil {
+			return
+		}
+
+		newIV := make([]byte, common.IVBlockSize)
+		rand.Read(newIV)
+
+		count := len(signatures) - sent
+		if count > wire.MaxSignaturesPerPacket {
+			count = wire.MaxSignaturesPerPacket
+		}
+
+		packet := wire.SignaturePacket{
+			NextInitializationVector: newIV,
+			BlockSize:                ctx.blockSize,
+			Signatures:               signatures[sent : sent+count],
+			Status:                   wire.More,
+			StatusText:               "More",
+		}
+
+		sent += count
+		if sent == len(signatures) {
+			packet.Status = wire.EOF
+			packet.StatusText = "EOF"
+		}
+
+		if e = sendEncrypted(ctx, packet); e != nil {
+			return
+		}
+
+		ctx.initializationVector = newIV
+
+		if packet.Status == wire.EOF {
+			return
+		}
+	}
+}
+
+// sendListing sends a directory listing, or err if the listing failed.  The
+// client requests each packet with a ClientDataRequest
+func sendListing(ctx *transferContext, entries []wire.FileEntry, err error) (e error) {
+	for sent := 0; ; {
+		if e = receiveDataRequest(ctx); e != nil {
+			return
+		}
+
+		newIV := make([]byte, common.IVBlockSize)
+		rand.Read(newIV)
+
+		count := len(entries) - sent
+		if count > wire.MaxEntriesPerPacket {
+			count = wire.MaxEntriesPerPacket
+		}
+
+		packet := wire.ListingPacket{
+			NextInitializationVector: newIV,
+			Entries:                  entries[sent : sent+count],
+			Status:                   wire.More,
+			StatusText:               "More",
+		}
+
+		sent += count
+		if err != nil {
+			packet.Status = common.ResponseCode(err)
+			packet.StatusText = err.Error()
+		} else if sent == len(entries) {
+			packet.Status = wire.EOF
+			packet.StatusText = "EOF"
+		}
+
+		if e = sendEncrypted(ctx, packet); e != nil {
+			return
+		}
+
+		ctx.initializationVector = newIV
+
+		if packet.Status != wire.More {
+			return reported(err)
+		}
+	}
+}
+
+// receiveDataRequest reads the ClientDataRequest a client sends before each
+// packet it expects from us
+func receiveDataRequest(ctx *transferContext) (e error) {
+	var read int
+	encrypted := make([]byte, wire.ReadBufferSize)
+	if read, e = ctx.conn.Read(encrypted); e != nil {
+		return
+	}
+
+	decrypted := common.DecryptAES(ctx.block, ctx.initializationVector, encrypted[:read])
+	decoder := gob.NewDecoder(bytes.NewBuffer(decrypted))
+	clientDataRequest := &wire.ClientDataRequest{}
+	if e = decoder.Decode(clientDataRequest); e != nil {
+		return
+	}
+
+	if clientDataRequest.Status == wire.Canceled {
+		return errClientCancelled
+	}
+
+	if clientDataRequest.Status != wire.More {
+		return errors.New(clientDataRequest.StatusText)
+	}
+
+	if ctx.ctx.Err() != nil {
+		return cancelTransfer(ctx)
+	}
+
+	return
+}
+
+// receiveKeyConfirmation reads the KeyConfirmation a client starts transfers
+// that change files with.  Nothing is changed until the client proved it holds
+// the session key
+func receiveKeyConfirmation(ctx *transferContext) (e error) {
+	var read int
+	encrypted := make([]byte, wire.ReadBufferSize)
+	if read, e = ctx.conn.Read(encrypted); e != nil {
+		return
+	}
+
+	decrypted := common.DecryptAES(ctx.block, ctx.initializationVector, encrypted[:read])
+	var confirmation wire.KeyConfirmation
+	if e = gob.NewDecoder(bytes.NewBuffer(decrypted)).Decode(&confirmation); e != nil {
+		return errKeyNotConfirmed
+	}
+
+	if confirmation.Status == wire.Canceled {
+		return errClientCancelled
+	}
+
+	if subtle.ConstantTimeCompare(confirmation.InitializationVector, ctx.initializationVector) != 1 {
+		return errKeyNotConfirmed
+	}
+
+	if ctx.ctx.Err() != nil {
+		return cancelTransfer(ctx)
+	}
+
+	return
+}
+
+// cancelTransfer answers the client's last message with the reason the
+// session was cancelled and returns it.  Every reply has a Status
+func cancelTransfer(ctx *transferContext) (e error) {
+	cause := context.Cause(ctx.ctx)
+	response := wire.StatusResponse{
+		Status:     wire.Canceled,
+		StatusText: cause.Error(),
+	}
+
+	if e = sendEncrypted(ctx, response); e != nil {
+		return
+	}
+
+	return cause
+}
+
+// sendEncrypted encodes msg and sends it encrypted with the current
+// initialization vector
+func sendEncrypted(ctx *transferContext, msg interface{}) (e error) {
+	var encoderBuffer bytes.Buffer
+	encoder := gob.NewEncoder(&encoderBuffer)
+	if e = encoder.Encode(msg); e != nil {
+		return
+	}
+
+	encrypted := common.EncryptAES(ctx.block, ctx.initializationVector, encoderBuffer.Bytes())
+	_, e = ctx.conn.Write(encrypted)
+
+	return
+}
+
+// receiveSignatures reads the signatures of the client's copy of a file it is
+// about to request as a delta
+func receiveSignatures(ctx *transferContext) (signatures []wire.BlockSignature, blockSize int, e error) {
+	for {
+		var read int
+		encrypted := make([]byte, wire.ReadBufferSize)
+		if read, e = ctx.conn.Read(encrypted); e != nil {
+			return
+		}
+
+		decrypted := common.DecryptAES(ctx.block, ctx.initializationVector, encrypted[:read])
+		decoder := gob.NewDecoder(bytes.NewBuffer(decrypted))
+		var packet wire.SignaturePacket
+		if e = decoder.Decode(&packet); e != nil {
+			return
+		}
+
+		if packet.Status == wire.Canceled {
+			e = errClientCancelled
+			return
+		}
+
+		if packet.Status != wire.More && packet.Status != wire.EOF {
+			e = errors.New(packet.StatusText)
+			return
+		}
+
+		if packet.BlockSize <= 0 {
+			e = errors.New("Invalid delta block size")
+			return
+		}
+
+		signatures = append(signatures, packet.Signatures...)
+		blockSize = packet.BlockSize
+
+		if e = sendClientReadResponse(ctx, nil); e != nil {
+			return
+		}
+
+		if packet.Status == wire.EOF {
+			return
+		}
+	}
+}
+
 func sendClientDataResponse(ctx *transferContext, iv []byte, data []byte, status wire.ResponseCode, statusText string) (e error) {
 
+	var compressed bool
+	if status == wire.OK {
+		if data, compressed, e = common.CompressChunk(ctx.compression, data); e != nil {
+			return
+		}
+	}
+
 	// note we send the next iv to client who will use it to encrypt the next message sent
 	// to us.  We use ctx.initializationVector to ecrypt this message
 	response := wire.ClientDataResponse{
 		NextInitializationVector: iv,
 		// tell client how much data we'll be sending
 		DataSize:   len(data),
+		Compressed: compressed,
 		Status:     status,
 		StatusText: statusText,
 	}
@@ -182,3 +566,108 @@ func sendClientDataResponse(ctx *transferContext, iv []byte, data []byte, status
 
 	return
 }
+
+// receiveFile writes the file the client sends to f and digest and commits f.
+// The metadata the client sends is limited to what userName may set
+func receiveFile(ctx *transferContext, f storage.PendingFile, digest io.Writer, userName string, options wire.TransferOption) (e error) {
+	if e = readRemoteWriteLocal(ctx, io.MultiWriter(f, digest)); e != io.EOF {
+		return
+	}
+
+	var md *wire.FileMetadata
+	if options.Has(wire.PreserveMetadata) {
+		if md, e = receiveMetadata(ctx); e != nil {
+			return
+		}
+		md = common.UserFileMetadata(md, userName)
+	}
+
+	err := f.Commit(md, options)
+	if e = sendClientReadResponse(ctx, err); e != nil {
+		return
+	}
+
+	return reported(err)
+}
+
+// receiveMetadata reads the file metadata the client sends after the last data
+// packet
+func receiveMetadata(ctx *transferContext) (md *wire.FileMetadata, e error) {
+	var read int
+	encrypted := make([]byte, wire.ReadBufferSize)
+	if read, e = ctx.conn.Read(encrypted); e != nil {
+		return
+	}
+
+	decrypted := common.DecryptAES(ctx.block, ctx.initializationVector, encrypted[:read])
+	decoder := gob.NewDecoder(bytes.NewBuffer(decrypted))
+
+	md = &wire.FileMetadata{}
+	if e = decoder.Decode(md); e != nil {
+		return nil, e
+	}
+
+	if md.Status == wire.Canceled {
+		return nil, errClientCancelled
+	}
+
+	return
+}
+
+// sendClientReadResponse tells the client whether the data it sent was stored
+// successfully
+func sendClientReadResponse(ctx *transferContext, err error) (e error) {
+	newIV := make([]byte, common.IVBlockSize)
+	rand.Read(newIV)
+
+	response := wire.ClientReadResponse{
+		NextInitializationVector: newIV,
+		Status:                   wire.OK,
+		StatusText:               "OK",
+	}
+
+	if err != nil {
+		response.Status = common.ResponseCode(err)
+		response.StatusText = err.Error()
+	}
+
+	var encoderBuffer bytes.Buffer
+	encoder := gob.NewEncoder(&encoderBuffer)
+	if e = encoder.Encode(response); e != nil {
+		return
+	}
+
+	encrypted := common.EncryptAES(ctx.block, ctx.initializationVector, encoderBuffer.Bytes())
+	if _, e = ctx.conn.Write(encrypted); e != nil {
+		return
+	}
+
+	ctx.initializationVector = newIV
+
+	return
+}
+
+// sendMetadata sends the attributes of file to the client after the last data
+// packet
+func sendMetadata(ctx *transferContext, file storage.File, options wire.TransferOption) (e error) {
+	md, err := file.Metadata(options)
+	if err != nil {
+		md = &wire.FileMetadata{
+			Status:     common.ResponseCode(err),
+			StatusText: err.Error(),
+		}
+	}
+
+	var encoderBuffer bytes.Buffer
+	encoder := gob.NewEncoder(&encoderBuffer)
+	if e = encoder.Encode(md); e != nil {
+		return
+	}
+
+	encrypted := common.EncryptAES(ctx.block, ctx.initializationVector, encoderBuffer.Bytes())
+	if _, e = ctx.conn.Write(encrypted); e != nil {
+		return
+	}
+
+	return reported(err)
+}
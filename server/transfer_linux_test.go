@@ -0,0 +1,109 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/murphybytes/ucp/common"
+	"github.com/murphybytes/ucp/storage"
+	"github.com/murphybytes/ucp/wire"
+)
+
+func TestReceiveFileMetadata(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Only root can set owners and trusted attributes")
+	}
+
+	usr, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip("No unprivileged user to upload as")
+	}
+	uid, _ := strconv.Atoi(usr.Uid)
+
+	dir, err := ioutil.TempDir("", "ucp")
+	if err != nil {
+		t.Fatal("Temp dir creation failed -", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	// a version 2 capability granting cap_net_bind_service
+	capability := []byte{1, 0, 0, 2, 0, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	options := wire.PreserveMetadata | wire.PreserveOwner | wire.PreserveXattrs
+
+	uploads := map[string]wire.FileMetadata{
+		"setuid": {Mode: uint32(os.ModeSetuid | os.ModeSetgid | 0755), UID: -1, GID: -1},
+		"owner":  {Mode: 0755, UID: 0, GID: 0},
+		"xattrs": {Mode: 0755, UID: -1, GID: -1, Xattrs: map[string][]byte{
+			"security.capability": capability,
+			"trusted.ucp":         []byte("trusted"),
+			"user.ucp":            []byte("user"),
+		}},
+	}
+
+	for name, md := range uploads {
+		iv := make([]byte, common.IVBlockSize)
+		rand.Read(iv)
+		block, _ := common.NewCipherBlock()
+
+		md.Status = wire.OK
+		conn := &mockRejectedConn{}
+		for _, msg := range []interface{}{wire.ClientRead{Status: wire.EOF, StatusText: "EOF"}, md} {
+			var buffer bytes.Buffer
+			if e := gob.NewEncoder(&buffer).Encode(msg); e != nil {
+				t.Fatal(e.Error())
+			}
+			conn.messages = append(conn.messages, common.EncryptAES(block, iv, buffer.Bytes()))
+		}
+
+		path := filepath.Join(dir, name)
+		pending, err := storage.Local{}.Create("nobody", path, false)
+		if err != nil {
+			t.Fatal("Create failed -", err.Error())
+		}
+
+		ctx := &transferContext{
+			ctx:                  context.Background(),
+			block:                block,
+			initializationVector: iv,
+			conn:                 conn,
+		}
+
+		if err = receiveFile(ctx, pending, ioutil.Discard, "nobody", options); err != nil {
+			t.Fatal("Upload of "+name+" failed -", err.Error())
+		}
+		pending.Close()
+
+		var info os.FileInfo
+		if info, err = os.Stat(path); err != nil {
+			t.Fatal("Stat failed -", err.Error())
+		}
+
+		if info.Mode()&(os.ModeSetuid|os.ModeSetgid) != 0 || info.Mode().Perm() != 0755 {
+			t.Error("Expected "+name+" to be 0755 without setuid or setgid, got", info.Mode())
+		}
+
+		if owner := info.Sys().(*syscall.Stat_t).Uid; int(owner) != uid {
+			t.Error("Expected "+name+" to belong to the user, got uid", owner)
+		}
+
+		for _, attribute := range []string{"security.capability", "trusted.ucp"} {
+			if _, err = syscall.Getxattr(path, attribute, nil); err != syscall.ENODATA {
+				t.Error("Expected "+name+" not to get "+attribute+", got", err)
+			}
+		}
+	}
+
+	value := make([]byte, 16)
+	if read, err := syscall.Getxattr(filepath.Join(dir, "xattrs"), "user.ucp", value); err != nil || string(value[:read]) != "user" {
+		t.Error("Expected attributes of the user namespace to be kept, got ", err)
+	}
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"crypto/cipher"
 	"crypto/rand"
 	"encoding/gob"
@@ -94,6 +95,7 @@ func getReadRemoteWriteLocalMock(sendBuffer []byte) (ctx *transferContext, f *mo
 	block, _ := common.NewCipherBlock()
 
 	ctx = &transferContext{
+		ctx:                  context.Background(),
 		block:                block,
 		initializationVector: iv,
 		conn: &mockClientConn{
@@ -195,6 +197,7 @@ func getMockClientReaderContext() (ctx *transferContext) {
 	}
 
 	ctx = &transferContext{
+		ctx:                  context.Background(),
 		block:                block,
 		initializationVector: iv,
 		conn:                 mockClient,
@@ -294,3 +297,165 @@ func TestReadLocalWriteRemote(t *testing.T) {
 	}
 
 }
+
+// mockRejectedConn reads the messages a client sends and keeps the reply
+type mockRejectedConn struct {
+	messages [][]byte
+	reply    []byte
+}
+
+func (m *mockRejectedConn) Read(b []byte) (n int, e error) {
+	if len(m.messages) == 0 {
+		return 0, errors.New("Read more messages than the client sent")
+	}
+
+	n = copy(b, m.messages[0])
+	m.messages = m.messages[1:]
+	return
+}
+
+func (m *mockRejectedConn) Write(b []byte) (n int, e error) {
+	m.reply = append([]byte{}, b...)
+	return len(b), nil
+}
+
+func (m *mockRejectedConn) Close() (e error) {
+	return
+}
+
+func TestRejectTransfer(t *testing.T) {
+	iv := make([]byte, common.IVBlockSize)
+	rand.Read(iv)
+	block, _ := common.NewCipherBlock()
+
+	// an empty upload with metadata sends the end of the file and the metadata
+	conn := &mockRejectedConn{}
+	for _, msg := range []interface{}{wire.ClientRead{Status: wire.EOF, StatusText: "EOF"}, wire.FileMetadata{Status: wire.OK}} {
+		var buffer bytes.Buffer
+		if e := gob.NewEncoder(&buffer).Encode(msg); e != nil {
+			t.Fatal(e.Error())
+		}
+		conn.messages = append(conn.messages, common.EncryptAES(block, iv, buffer.Bytes()))
+	}
+
+	ctx := &transferContext{
+		ctx:                  context.Background(),
+		block:                block,
+		initializationVector: iv,
+		conn:                 conn,
+	}
+
+	err := errors.New("Permission denied")
+	e := rejectTransfer(ctx, true, err)
+	if !errors.Is(e, err) || !errors.As(e, &reportedError{}) {
+		t.Fatal("Expected a reported error got ", e)
+	}
+
+	if len(conn.messages) != 0 {
+		t.Fatal("Expected the metadata to be read")
+	}
+
+	var response wire.ClientReadResponse
+	decrypted := common.DecryptAES(block, iv, conn.reply)
+	if e = gob.NewDecoder(bytes.NewBuffer(decrypted)).Decode(&response); e != nil {
+		t.Fatal("Reply should decode as the reply the client expects - ", e.Error())
+	}
+
+	if response.Status != wire.Error || response.StatusText != err.Error() {
+		t.Error("Unexpected reply ", response.Status, " ", response.StatusText)
+	}
+}
+
+func TestCancelTransfer(t *testing.T) {
+	iv := make([]byte, common.IVBlockSize)
+	rand.Read(iv)
+	block, _ := common.NewCipherBlock()
+
+	encrypt := func(msg interface{}) []byte {
+		var buffer bytes.Buffer
+		if e := gob.NewEncoder(&buffer).Encode(msg); e != nil {
+			t.Fatal(e.Error())
+		}
+		return common.EncryptAES(block, iv, buffer.Bytes())
+	}
+
+	// a client that cancels sends a status in place of its next message
+	conn := &mockRejectedConn{messages: [][]byte{encrypt(wire.StatusResponse{Status: wire.Canceled, StatusText: "Canceled"})}}
+	ctx := &transferContext{
+		ctx:                  context.Background(),
+		block:                block,
+		initializationVector: iv,
+		conn:                 conn,
+	}
+
+	if e := readRemoteWriteLocal(ctx, &mockServerFile{}); e != errClientCancelled || conn.reply != nil {
+		t.Error("Expected the transfer to end without a reply, got ", e)
+	}
+
+	// a cancelled session answers the client's next message
+	cancelled, stop := context.WithCancelCause(context.Background())
+	stop(errCancelled)
+
+	conn = &mockRejectedConn{messages: [][]byte{encrypt(wire.ClientDataRequest{Status: wire.More, StatusText: "More"})}}
+	ctx = &transferContext{
+		ctx:                  cancelled,
+		block:                block,
+		initializationVector: iv,
+		conn:                 conn,
+	}
+
+	if e := readLocalWriteRemote(ctx, bytes.NewReader([]byte("data"))); e != errCancelled {
+		t.Fatal("Expected the cancellation cause got ", e)
+	}
+
+	var response wire.ClientDataResponse
+	decrypted := common.DecryptAES(block, iv, conn.reply)
+	if e := gob.NewDecoder(bytes.NewBuffer(decrypted)).Decode(&response); e != nil {
+		t.Fatal("Reply should decode as the reply the client expects - ", e.Error())
+	}
+
+	if response.Status != wire.Canceled || response.StatusText != errCancelled.Error() {
+		t.Error("Unexpected reply ", response.Status, " ", response.StatusText)
+	}
+}
+
+func TestReceiveKeyConfirmation(t *testing.T) {
+	iv := make([]byte, common.IVBlockSize)
+	rand.Read(iv)
+	block, _ := common.NewCipherBlock()
+	other, _ := common.NewCipherBlock()
+
+	encrypt := func(block cipher.Block, msg interface{}) []byte {
+		var buffer bytes.Buffer
+		if e := gob.NewEncoder(&buffer).Encode(msg); e != nil {
+			t.Fatal(e.Error())
+		}
+		return common.EncryptAES(block, iv, buffer.Bytes())
+	}
+
+	confirm := func(message []byte) error {
+		return receiveKeyConfirmation(&transferContext{
+			ctx:                  context.Background(),
+			block:                block,
+			initializationVector: iv,
+			conn:                 &mockRejectedConn{messages: [][]byte{message}},
+		})
+	}
+
+	if e := confirm(encrypt(block, wire.KeyConfirmation{InitializationVector: iv, Status: wire.OK})); e != nil {
+		t.Error("Expected the confirmation to be accepted, got ", e)
+	}
+
+	// a client that could not decrypt the key can't echo the vector
+	if e := confirm(encrypt(other, wire.KeyConfirmation{InitializationVector: iv, Status: wire.OK})); e != errKeyNotConfirmed {
+		t.Error("Expected a message encrypted with another key to be rejected, got ", e)
+	}
+
+	if e := confirm(encrypt(block, wire.KeyConfirmation{Status: wire.OK})); e != errKeyNotConfirmed {
+		t.Error("Expected a confirmation without the vector to be rejected, got ", e)
+	}
+
+	if e := confirm(encrypt(block, wire.StatusResponse{Status: wire.Canceled})); e != errClientCancelled {
+		t.Error("Expected a cancelled transfer, got ", e)
+	}
+}
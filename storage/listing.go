@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"crypto/md5"
+	"io"
+	"os"
+	"path"
+
+	"github.com/murphybytes/ucp/common"
+	"github.com/murphybytes/ucp/wire"
+)
+
+// ListTree returns an entry for every regular file and directory below the
+// directory root, parents before their children.  If root does not exist the
+// listing is empty.  When checksums is true the contents of every file are
+// read to compute its checksum
+func ListTree(fs FS, userName, root string, checksums bool) (entries []wire.FileEntry, e error) {
+	if _, e = fs.Stat(userName, root); os.IsNotExist(e) {
+		return nil, nil
+	}
+
+	if e != nil {
+		return
+	}
+
+	e = walk(fs, userName, root, "", checksums, &entries)
+
+	return
+}
+
+func walk(fs FS, userName, root, prefix string, checksums bool, entries *[]wire.FileEntry) (e error) {
+	var infos []os.FileInfo
+	if infos, e = fs.ReadDir(userName, path.Join(root, prefix)); e != nil {
+		return
+	}
+
+	for _, info := range infos {
+		if !(info.Mode().IsRegular() || info.IsDir()) {
+			continue
+		}
+
+		name := path.Join(prefix, info.Name())
+
+		var entry wire.FileEntry
+		if entry, e = fileEntry(fs, userName, name, path.Join(root, name), info, checksums); e != nil {
+			return
+		}
+		*entries = append(*entries, entry)
+
+		if info.IsDir() {
+			if e = walk(fs, userName, root, name, checksums, entries); e != nil {
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// ListDir returns an entry for every regular file and directory directly
+// inside the directory at dir.  If dir is a file the listing holds only its
+// own entry
+func ListDir(fs FS, userName, dir string, checksums bool) (entries []wire.FileEntry, e error) {
+	var info os.FileInfo
+	if info, e = fs.Stat(userName, dir); e != nil {
+		return
+	}
+
+	if !info.IsDir() {
+		var entry wire.FileEntry
+		if entry, e = fileEntry(fs, userName, info.Name(), dir, info, checksums); e != nil {
+			return
+		}
+		return []wire.FileEntry{entry}, nil
+	}
+
+	var infos []os.FileInfo
+	if infos, e = fs.ReadDir(userName, dir); e != nil {
+		return
+	}
+
+	for _, info = range infos {
+		if !(info.Mode().IsRegular() || info.IsDir()) {
+			continue
+		}
+
+		var entry wire.FileEntry
+		if entry, e = fileEntry(fs, userName, info.Name(), path.Join(dir, info.Name()), info, checksums); e != nil {
+			return
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return
+}
+
+// StatEntry returns the entry of the file or directory at filePath, named
+// after its last element
+func StatEntry(fs FS, userName, filePath string, checksums bool) (entry wire.FileEntry, e error) {
+	var info os.FileInfo
+	if info, e = fs.Stat(userName, filePath); e != nil {
+		return
+	}
+
+	return fileEntry(fs, userName, info.Name(), filePath, info, checksums)
+}
+
+func fileEntry(fs FS, userName, name, filePath string, info os.FileInfo, checksums bool) (entry wire.FileEntry, e error) {
+	entry = wire.FileEntry{
+		Name:    name,
+		Size:    info.Size(),
+		ModTime: info.ModTime().UnixNano(),
+		Mode:    uint32(info.Mode() & common.PreservedModeBits),
+		IsDir:   info.IsDir(),
+	}
+
+	if checksums && !info.IsDir() {
+		entry.Checksum, e = fileChecksum(fs, userName, filePath)
+	}
+
+	return
+}
+
+func fileChecksum(fs FS, userName, filePath string) (checksum []byte, e error) {
+	var f File
+	if f, e = fs.Open(userName, filePath); e != nil {
+		return
+	}
+	defer f.Close()
+
+	hash := md5.New()
+	if _, e = io.Copy(hash, f); e != nil {
+		return
+	}
+
+	return hash.Sum(nil), nil
+}
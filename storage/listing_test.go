@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+)
+
+func TestListDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ucp")
+	if err != nil {
+		t.Fatal("Temp dir creation failed -", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	current, err := user.Current()
+	if err != nil {
+		t.Fatal("Current user lookup failed -", err.Error())
+	}
+
+	if err = os.MkdirAll(filepath.Join(dir, "sub", "deeper"), 0755); err != nil {
+		t.Fatal("Mkdir failed -", err.Error())
+	}
+
+	file := filepath.Join(dir, "file")
+	if err = ioutil.WriteFile(file, []byte("contents"), 0640); err != nil {
+		t.Fatal("Write failed -", err.Error())
+	}
+
+	entries, err := ListDir(Local{}, current.Username, dir, false)
+	if err != nil {
+		t.Fatal("ListDir failed -", err.Error())
+	}
+
+	if len(entries) != 2 || entries[0].Name != "file" || entries[1].Name != "sub" {
+		t.Fatal("Expected file and sub only, got ", entries)
+	}
+
+	if entries[0].Size != 8 || entries[0].IsDir || !entries[1].IsDir {
+		t.Error("Unexpected entries ", entries)
+	}
+
+	if entries, err = ListDir(Local{}, current.Username, file, true); err != nil || len(entries) != 1 || entries[0].Name != "file" || entries[0].Checksum == nil {
+		t.Error("A file should list itself with its checksum, got ", entries, err)
+	}
+
+	if _, err = ListDir(Local{}, current.Username, filepath.Join(dir, "missing"), false); !os.IsNotExist(err) {
+		t.Error("Expected a missing directory to fail, got ", err)
+	}
+}
+
+func TestStatEntry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ucp")
+	if err != nil {
+		t.Fatal("Temp dir creation failed -", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	current, err := user.Current()
+	if err != nil {
+		t.Fatal("Current user lookup failed -", err.Error())
+	}
+
+	entry, err := StatEntry(Local{}, current.Username, dir, false)
+	if err != nil {
+		t.Fatal("StatEntry failed -", err.Error())
+	}
+
+	if entry.Name != filepath.Base(dir) || !entry.IsDir {
+		t.Error("Unexpected entry ", entry)
+	}
+
+	if _, err = StatEntry(Local{}, current.Username, filepath.Join(dir, "missing"), false); !os.IsNotExist(err) {
+		t.Error("Expected a missing file to fail, got ", err)
+	}
+}
@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/murphybytes/ucp/common"
+	"github.com/murphybytes/ucp/wire"
+)
+
+// Local serves the local file system.  Relative paths are relative to the
+// home directory of the user
+type Local struct{}
+
+// Open opens the file at path for reading
+func (Local) Open(userName, path string) (File, error) {
+	f, e := common.Open(path, userName)
+	if e != nil {
+		return nil, e
+	}
+
+	return localFile{f}, nil
+}
+
+// Create returns a temporary file next to path that is renamed to path once it
+// is committed
+func (Local) Create(userName, path string, parents bool) (PendingFile, error) {
+	if parents {
+		fullPath, e := common.UserPath(path, userName)
+		if e != nil {
+			return nil, e
+		}
+
+		if e = os.MkdirAll(filepath.Dir(fullPath), 0777); e != nil {
+			return nil, e
+		}
+	}
+
+	f, e := common.Create(path, userName)
+	if e != nil {
+		return nil, e
+	}
+
+	return localPendingFile{f}, nil
+}
+
+// Stat describes the file or directory at path
+func (Local) Stat(userName, path string) (os.FileInfo, error) {
+	fullPath, e := common.UserPath(path, userName)
+	if e != nil {
+		return nil, e
+	}
+
+	return os.Stat(fullPath)
+}
+
+// ReadDir describes the entries of the directory at path sorted by name
+func (Local) ReadDir(userName, path string) (infos []os.FileInfo, e error) {
+	var fullPath string
+	if fullPath, e = common.UserPath(path, userName); e != nil {
+		return
+	}
+
+	var entries []os.DirEntry
+	if entries, e = os.ReadDir(fullPath); e != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		var info os.FileInfo
+		if info, e = entry.Info(); e != nil {
+			return nil, e
+		}
+		infos = append(infos, info)
+	}
+
+	return
+}
+
+// Mkdir creates the directory at path, and its missing parents if parents is
+// true
+func (Local) Mkdir(userName, path string, parents bool) error {
+	fullPath, e := common.UserPath(path, userName)
+	if e != nil {
+		return e
+	}
+
+	if parents {
+		return os.MkdirAll(fullPath, 0777)
+	}
+
+	return os.Mkdir(fullPath, 0777)
+}
+
+// Rename moves the file or directory at from to to
+func (Local) Rename(userName, from, to string) (e error) {
+	if from, e = common.UserPath(from, userName); e != nil {
+		return
+	}
+
+	if to, e = common.UserPath(to, userName); e != nil {
+		return
+	}
+
+	return os.Rename(from, to)
+}
+
+// Remove removes the file or empty directory at path
+func (Local) Remove(userName, path string) error {
+	fullPath, e := common.UserPath(path, userName)
+	if e != nil {
+		return e
+	}
+
+	return os.Remove(fullPath)
+}
+
+type localFile struct {
+	*os.File
+}
+
+func (f localFile) Metadata(options wire.TransferOption) (*wire.FileMetadata, error) {
+	return common.GetFileMetadata(f.Name(), options)
+}
+
+type localPendingFile struct {
+	*common.AtomicFile
+}
+
+func (f localPendingFile) Commit(md *wire.FileMetadata, options wire.TransferOption) (e error) {
+	if md != nil {
+		if e = common.ApplyFileMetadata(f.Name(), md, options); e != nil {
+			return
+		}
+	}
+
+	return f.AtomicFile.Commit()
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/murphybytes/ucp/wire"
+)
+
+func TestLocal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ucp-storage")
+	if err != nil {
+		t.Fatal("Temp dir creation failed -", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	current, err := user.Current()
+	if err != nil {
+		t.Fatal("Current user lookup failed -", err.Error())
+	}
+
+	var fs FS = Local{}
+	path := filepath.Join(dir, "sub", "file")
+
+	if _, err = fs.Create(current.Username, path, false); err == nil {
+		t.Fatal("Expected a missing parent directory to fail")
+	}
+
+	pending, err := fs.Create(current.Username, path, true)
+	if err != nil {
+		t.Fatal("Create failed -", err.Error())
+	}
+	pending.Write([]byte("contents"))
+
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("The file should not exist before it is committed")
+	}
+
+	modified := time.Unix(1500000000, 0)
+	md := &wire.FileMetadata{Mode: 0600, AccessTime: modified.UnixNano(), ModTime: modified.UnixNano()}
+	if err = pending.Commit(md, wire.PreserveMetadata); err != nil {
+		t.Fatal("Commit failed -", err.Error())
+	}
+	pending.Close()
+
+	file, err := fs.Open(current.Username, path)
+	if err != nil {
+		t.Fatal("Open failed -", err.Error())
+	}
+	defer file.Close()
+
+	if contents, _ := ioutil.ReadAll(file); string(contents) != "contents" {
+		t.Error("Unexpected contents ", string(contents))
+	}
+
+	if md, err = file.Metadata(wire.PreserveMetadata); err != nil {
+		t.Fatal("Metadata failed -", err.Error())
+	}
+
+	if os.FileMode(md.Mode).Perm() != 0600 || md.ModTime != modified.UnixNano() {
+		t.Error("Metadata was not applied ", md.Mode, " ", md.ModTime)
+	}
+
+	if err = fs.Mkdir(current.Username, filepath.Join(dir, "sub"), false); !os.IsExist(err) {
+		t.Error("Expected creating an existing directory to fail, got ", err)
+	}
+
+	if err = fs.Mkdir(current.Username, filepath.Join(dir, "new", "sub"), true); err != nil {
+		t.Error("Mkdir failed -", err.Error())
+	}
+}
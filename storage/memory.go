@@ -0,0 +1,332 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/murphybytes/ucp/common"
+	"github.com/murphybytes/ucp/wire"
+)
+
+var (
+	errIsDir    = errors.New("is a directory")
+	errNotDir   = errors.New("not a directory")
+	errNotEmpty = errors.New("directory not empty")
+)
+
+// Memory keeps files in memory, for tests.  Every user sees the same files
+// and relative paths are relative to the root
+type Memory struct {
+	mutex sync.Mutex
+	// nodes by clean absolute path, the root always exists
+	nodes map[string]*memoryNode
+}
+
+type memoryNode struct {
+	data    []byte
+	mode    os.FileMode
+	modTime time.Time
+}
+
+// NewMemory returns an empty Memory
+func NewMemory() *Memory {
+	return &Memory{
+		nodes: map[string]*memoryNode{
+			"/": {mode: os.ModeDir | 0755, modTime: time.Now()},
+		},
+	}
+}
+
+func memoryPath(name string) string {
+	return path.Join("/", name)
+}
+
+// MkdirAll creates the directory at dir and any missing parents
+func (m *Memory) MkdirAll(dir string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.mkdirAll(memoryPath(dir))
+}
+
+func (m *Memory) mkdirAll(dir string) error {
+	if node, ok := m.nodes[dir]; ok {
+		if !node.mode.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: dir, Err: os.ErrExist}
+		}
+		return nil
+	}
+
+	if e := m.mkdirAll(path.Dir(dir)); e != nil {
+		return e
+	}
+
+	m.nodes[dir] = &memoryNode{mode: os.ModeDir | 0755, modTime: time.Now()}
+
+	return nil
+}
+
+// Open opens the file at name for reading
+func (m *Memory) Open(userName, name string) (File, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	node, ok := m.nodes[memoryPath(name)]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
+	if node.mode.IsDir() {
+		return nil, &os.PathError{Op: "read", Path: name, Err: errIsDir}
+	}
+
+	return &memoryFile{
+		Reader:  bytes.NewReader(node.data),
+		mode:    node.mode,
+		modTime: node.modTime,
+	}, nil
+}
+
+// Create returns a file that replaces the file at name once it is committed
+func (m *Memory) Create(userName, name string, parents bool) (PendingFile, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	name = memoryPath(name)
+	if node, ok := m.nodes[name]; ok && node.mode.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: name, Err: errIsDir}
+	}
+
+	if parents {
+		if e := m.mkdirAll(path.Dir(name)); e != nil {
+			return nil, e
+		}
+	} else if parent, ok := m.nodes[path.Dir(name)]; !ok || !parent.mode.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
+	return &memoryPendingFile{memory: m, name: name}, nil
+}
+
+// Stat describes the file or directory at name
+func (m *Memory) Stat(userName, name string) (os.FileInfo, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	name = memoryPath(name)
+	node, ok := m.nodes[name]
+	if !ok {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+
+	return node.info(path.Base(name)), nil
+}
+
+// ReadDir describes the entries of the directory at name sorted by name
+func (m *Memory) ReadDir(userName, name string) (infos []os.FileInfo, e error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	name = memoryPath(name)
+	node, ok := m.nodes[name]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
+	if !node.mode.IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: errNotDir}
+	}
+
+	for _, child := range m.children(name) {
+		infos = append(infos, m.nodes[child].info(path.Base(child)))
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+
+	return
+}
+
+// Mkdir creates the directory at name, and its missing parents if parents is
+// true
+func (m *Memory) Mkdir(userName, name string, parents bool) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	name = memoryPath(name)
+	if parents {
+		return m.mkdirAll(name)
+	}
+
+	if _, ok := m.nodes[name]; ok {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+
+	if parent, ok := m.nodes[path.Dir(name)]; !ok || !parent.mode.IsDir() {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrNotExist}
+	}
+
+	m.nodes[name] = &memoryNode{mode: os.ModeDir | 0755, modTime: time.Now()}
+
+	return nil
+}
+
+// Rename moves the file or directory at from to to, replacing a file at to
+func (m *Memory) Rename(userName, from, to string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	from, to = memoryPath(from), memoryPath(to)
+	node, ok := m.nodes[from]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: os.ErrNotExist}
+	}
+
+	if parent, ok := m.nodes[path.Dir(to)]; !ok || !parent.mode.IsDir() {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: os.ErrNotExist}
+	}
+
+	if existing, ok := m.nodes[to]; ok && (existing.mode.IsDir() || node.mode.IsDir()) {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: os.ErrExist}
+	}
+
+	if node.mode.IsDir() && strings.HasPrefix(to, from+"/") {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: os.ErrInvalid}
+	}
+
+	moved := make(map[string]*memoryNode)
+	for name, node := range m.nodes {
+		if name == from || strings.HasPrefix(name, from+"/") {
+			moved[to+strings.TrimPrefix(name, from)] = node
+			delete(m.nodes, name)
+		}
+	}
+
+	for name, node := range moved {
+		m.nodes[name] = node
+	}
+
+	return nil
+}
+
+// Remove removes the file or empty directory at name
+func (m *Memory) Remove(userName, name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	name = memoryPath(name)
+	node, ok := m.nodes[name]
+	if !ok || name == "/" {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+
+	if node.mode.IsDir() && len(m.children(name)) > 0 {
+		return &os.PathError{Op: "remove", Path: name, Err: errNotEmpty}
+	}
+
+	delete(m.nodes, name)
+
+	return nil
+}
+
+// children returns the paths of the nodes directly inside dir
+func (m *Memory) children(dir string) (names []string) {
+	for name := range m.nodes {
+		if name != "/" && path.Dir(name) == dir {
+			names = append(names, name)
+		}
+	}
+
+	return
+}
+
+func (n *memoryNode) info(name string) os.FileInfo {
+	return &fileInfo{
+		name:    name,
+		size:    int64(len(n.data)),
+		mode:    n.mode,
+		modTime: n.modTime,
+	}
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (i *fileInfo) Name() string       { return i.name }
+func (i *fileInfo) Size() int64        { return i.size }
+func (i *fileInfo) Mode() os.FileMode  { return i.mode }
+func (i *fileInfo) ModTime() time.Time { return i.modTime }
+func (i *fileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *fileInfo) Sys() interface{}   { return nil }
+
+type memoryFile struct {
+	*bytes.Reader
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (f *memoryFile) Close() error {
+	return nil
+}
+
+// Metadata returns the mode and times of the file, owners and extended
+// attributes are not kept
+func (f *memoryFile) Metadata(options wire.TransferOption) (*wire.FileMetadata, error) {
+	return &wire.FileMetadata{
+		Mode:       uint32(f.mode & common.PreservedModeBits),
+		ModTime:    f.modTime.UnixNano(),
+		AccessTime: f.modTime.UnixNano(),
+		UID:        -1,
+		GID:        -1,
+		Status:     wire.OK,
+		StatusText: "OK",
+	}, nil
+}
+
+type memoryPendingFile struct {
+	bytes.Buffer
+	memory *Memory
+	name   string
+}
+
+func (f *memoryPendingFile) Close() error {
+	return nil
+}
+
+// Commit stores the file, applying the mode and modification time of md
+func (f *memoryPendingFile) Commit(md *wire.FileMetadata, options wire.TransferOption) error {
+	node := &memoryNode{
+		data:    f.Bytes(),
+		mode:    0644,
+		modTime: time.Now(),
+	}
+
+	if md != nil {
+		node.mode = os.FileMode(md.Mode) & common.PreservedModeBits
+		node.modTime = time.Unix(0, md.ModTime)
+	}
+
+	f.memory.mutex.Lock()
+	defer f.memory.mutex.Unlock()
+
+	if parent, ok := f.memory.nodes[path.Dir(f.name)]; !ok || !parent.mode.IsDir() {
+		return &os.PathError{Op: "rename", Path: f.name, Err: os.ErrNotExist}
+	}
+
+	if existing, ok := f.memory.nodes[f.name]; ok && existing.mode.IsDir() {
+		return &os.PathError{Op: "rename", Path: f.name, Err: errIsDir}
+	}
+
+	f.memory.nodes[f.name] = node
+
+	return nil
+}
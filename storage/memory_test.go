@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/murphybytes/ucp/wire"
+)
+
+func TestMemory(t *testing.T) {
+	fs := NewMemory()
+
+	if _, err := fs.Create("user", "/dir/file", false); !os.IsNotExist(err) {
+		t.Fatal("Expected a missing parent directory to fail, got ", err)
+	}
+
+	pending, err := fs.Create("user", "dir/file", true)
+	if err != nil {
+		t.Fatal("Create failed -", err.Error())
+	}
+	pending.Write([]byte("contents"))
+
+	if _, err = fs.Stat("user", "/dir/file"); !os.IsNotExist(err) {
+		t.Fatal("The file should not exist before it is committed")
+	}
+
+	modified := time.Unix(1500000000, 0)
+	if err = pending.Commit(&wire.FileMetadata{Mode: 0600, ModTime: modified.UnixNano()}, wire.PreserveMetadata); err != nil {
+		t.Fatal("Commit failed -", err.Error())
+	}
+
+	file, err := fs.Open("user", "/dir/file")
+	if err != nil {
+		t.Fatal("Open failed -", err.Error())
+	}
+
+	if contents, _ := ioutil.ReadAll(file); string(contents) != "contents" {
+		t.Error("Unexpected contents ", string(contents))
+	}
+
+	if md, _ := file.Metadata(0); md.Mode != 0600 || md.ModTime != modified.UnixNano() {
+		t.Error("Metadata was not applied ", md.Mode, " ", md.ModTime)
+	}
+
+	if err = fs.Remove("user", "/dir"); err == nil {
+		t.Error("Expected removing a directory that is not empty to fail")
+	}
+
+	if err = fs.Rename("user", "/dir", "/moved"); err != nil {
+		t.Fatal("Rename failed -", err.Error())
+	}
+
+	entries, err := ListTree(fs, "user", "/", false)
+	if err != nil {
+		t.Fatal("ListTree failed -", err.Error())
+	}
+
+	if len(entries) != 2 || entries[0].Name != "moved" || entries[1].Name != "moved/file" || entries[1].Size != 8 {
+		t.Error("Unexpected entries ", entries)
+	}
+
+	if err = fs.Remove("user", "/moved/file"); err != nil {
+		t.Error("Remove failed -", err.Error())
+	}
+
+	if err = fs.Remove("user", "/moved/file"); !os.IsNotExist(err) {
+		t.Error("Expected removing a missing file to fail, got ", err)
+	}
+
+	if err = fs.Mkdir("user", "/new/sub", false); !os.IsNotExist(err) {
+		t.Error("Expected a missing parent directory to fail, got ", err)
+	}
+
+	if err = fs.Mkdir("user", "/new/sub", true); err != nil {
+		t.Fatal("Mkdir failed -", err.Error())
+	}
+
+	if err = fs.Mkdir("user", "/new", false); !os.IsExist(err) {
+		t.Error("Expected creating an existing directory to fail, got ", err)
+	}
+
+	if info, err := fs.Stat("user", "/new/sub"); err != nil || !info.IsDir() {
+		t.Error("Expected a directory, got ", err)
+	}
+}
@@ -0,0 +1,973 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/murphybytes/ucp/common"
+	"github.com/murphybytes/ucp/wire"
+)
+
+const (
+	// DefaultS3PartSize is the size of the first parts large files are
+	// uploaded in
+	DefaultS3PartSize = 8 << 20
+	// s3PartsPerSize parts are uploaded before the part size doubles, which
+	// keeps files of any size the service stores within its 10,000 parts
+	s3PartsPerSize = 1000
+	s3MaxPartSize  = 5 << 30
+	// s3ReadAhead is how much a ranged read fetches at least, delta transfers
+	// read blocks that are much smaller
+	s3ReadAhead = 1 << 20
+
+	s3ModeHeader    = "X-Amz-Meta-Ucp-Mode"
+	s3ModTimeHeader = "X-Amz-Meta-Ucp-Mtime"
+	s3TimeFormat    = "20060102T150405Z"
+	emptyPayload    = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// S3 stores files as objects in a bucket of an S3 compatible service.  The
+// files of each user are kept below a prefix named after the user, paths are
+// relative to it whether they are absolute or not.  Directories exist as long
+// as they hold files.  Files are written with multipart uploads once they
+// outgrow a part and read with ranged GETs.  Listings report the time objects
+// were stored, the mode and time preserved with them are returned by Stat
+type S3 struct {
+	// Endpoint is the URL of the service such as https://s3.amazonaws.com,
+	// buckets are addressed by path
+	Endpoint string
+	Bucket   string
+	Region   string
+	// AccessKey and SecretKey sign requests
+	AccessKey string
+	SecretKey string
+	// SessionToken is sent along with temporary credentials
+	SessionToken string
+	// PartSize is the size of the first parts of multipart uploads,
+	// DefaultS3PartSize if 0
+	PartSize int
+	// Client sends requests, http.DefaultClient if nil
+	Client *http.Client
+}
+
+type s3Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+type s3ListResult struct {
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []s3Object
+	CommonPrefixes        []struct {
+		Prefix string
+	}
+}
+
+type s3ErrorResponse struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string
+	Message string
+}
+
+// key returns the object key of name for userName
+func (s *S3) key(userName, name string) string {
+	return path.Join(userName, path.Join("/", name))
+}
+
+// Open opens the file at name for reading
+func (s *S3) Open(userName, name string) (File, error) {
+	key := s.key(userName, name)
+	resp, e := s.do("HEAD", key, nil, nil, nil)
+	if e != nil {
+		return nil, e
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, s3Error("open", name, resp)
+	}
+
+	return &s3File{
+		s3:   s,
+		key:  key,
+		size: resp.ContentLength,
+		etag: resp.Header.Get("ETag"),
+		md:   s3Metadata(resp.Header),
+	}, nil
+}
+
+// Create returns a file that is stored at name once it is committed.  Files
+// larger than a part are uploaded under a temporary name next to it.  There
+// are no directories to create so parents is ignored
+func (s *S3) Create(userName, name string, parents bool) (PendingFile, error) {
+	suffix := make([]byte, 8)
+	if _, e := rand.Read(suffix); e != nil {
+		return nil, e
+	}
+
+	key := s.key(userName, name)
+	return &s3PendingFile{
+		s3:        s,
+		key:       key,
+		uploadKey: path.Join(path.Dir(key), "."+path.Base(key)+".ucp-"+hex.EncodeToString(suffix)),
+	}, nil
+}
+
+// Stat describes the file or directory at name
+func (s *S3) Stat(userName, name string) (os.FileInfo, error) {
+	key := s.key(userName, name)
+	if key == userName {
+		return &fileInfo{name: path.Base(name), mode: os.ModeDir | 0755}, nil
+	}
+
+	info, e := s.statObject(key)
+	if !os.IsNotExist(e) {
+		return info, e
+	}
+
+	var objects []s3Object
+	var prefixes []string
+	if objects, prefixes, e = s.list(key+"/", true, 1); e != nil {
+		return nil, e
+	}
+
+	if len(objects) == 0 && len(prefixes) == 0 {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+
+	return &fileInfo{name: path.Base(key), mode: os.ModeDir | 0755}, nil
+}
+
+// ReadDir describes the entries of the directory at name sorted by name.
+// Listings don't include the metadata of objects so files are 0644 and
+// modified when they were stored
+func (s *S3) ReadDir(userName, name string) (infos []os.FileInfo, e error) {
+	key := s.key(userName, name)
+
+	var objects []s3Object
+	var prefixes []string
+	if objects, prefixes, e = s.list(key+"/", true, 0); e != nil {
+		return
+	}
+
+	if len(objects) == 0 && len(prefixes) == 0 && key != userName {
+		if _, e = s.statObject(key); e == nil {
+			return nil, &os.PathError{Op: "readdir", Path: name, Err: errNotDir}
+		}
+
+		if os.IsNotExist(e) {
+			return nil, &os.PathError{Op: "readdir", Path: name, Err: os.ErrNotExist}
+		}
+		return nil, e
+	}
+
+	for _, object := range objects {
+		infos = append(infos, &fileInfo{
+			name:    path.Base(object.Key),
+			size:    object.Size,
+			mode:    0644,
+			modTime: object.LastModified,
+		})
+	}
+
+	for _, prefix := range prefixes {
+		infos = append(infos, &fileInfo{name: path.Base(prefix), mode: os.ModeDir | 0755})
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+
+	return
+}
+
+// Mkdir only checks that there is no file at name, directories appear once
+// files are stored in them
+func (s *S3) Mkdir(userName, name string, parents bool) (e error) {
+	key := s.key(userName, name)
+	if key == userName {
+		return nil
+	}
+
+	if _, e = s.statObject(key); e == nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+
+	if os.IsNotExist(e) {
+		return nil
+	}
+
+	return
+}
+
+// Rename copies the file or every file of the directory at from to to and
+// removes the originals
+func (s *S3) Rename(userName, from, to string) (e error) {
+	fromKey, toKey := s.key(userName, from), s.key(userName, to)
+	if fromKey == userName || toKey == userName {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: os.ErrInvalid}
+	}
+
+	if _, e = s.statObject(fromKey); e == nil {
+		if e = s.copyObject(fromKey, toKey); e != nil {
+			return
+		}
+		return s.deleteObject(fromKey)
+	}
+
+	if !os.IsNotExist(e) {
+		return
+	}
+
+	var objects []s3Object
+	if objects, _, e = s.list(fromKey+"/", false, 0); e != nil {
+		return
+	}
+
+	if len(objects) == 0 {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: os.ErrNotExist}
+	}
+
+	for _, object := range objects {
+		if e = s.copyObject(object.Key, toKey+strings.TrimPrefix(object.Key, fromKey)); e != nil {
+			return
+		}
+
+		if e = s.deleteObject(object.Key); e != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// Remove removes the file at name.  A directory without files doesn't exist
+// so removing it succeeds
+func (s *S3) Remove(userName, name string) (e error) {
+	key := s.key(userName, name)
+	if key == userName {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrInvalid}
+	}
+
+	if _, e = s.statObject(key); e == nil {
+		return s.deleteObject(key)
+	}
+
+	if !os.IsNotExist(e) {
+		return
+	}
+
+	var objects []s3Object
+	if objects, _, e = s.list(key+"/", false, 1); e != nil {
+		return
+	}
+
+	if len(objects) > 0 {
+		return &os.PathError{Op: "remove", Path: name, Err: errNotEmpty}
+	}
+
+	return nil
+}
+
+func (s *S3) statObject(key string) (os.FileInfo, error) {
+	resp, e := s.do("HEAD", key, nil, nil, nil)
+	if e != nil {
+		return nil, e
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, s3Error("stat", key, resp)
+	}
+
+	md := s3Metadata(resp.Header)
+
+	return &fileInfo{
+		name:    path.Base(key),
+		size:    resp.ContentLength,
+		mode:    os.FileMode(md.Mode),
+		modTime: time.Unix(0, md.ModTime),
+	}, nil
+}
+
+// list returns the objects below prefix and, if delimited, the prefixes of
+// the directories directly below it.  At most max objects and prefixes are
+// returned unless max is 0
+func (s *S3) list(prefix string, delimited bool, max int) (objects []s3Object, prefixes []string, e error) {
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	if delimited {
+		query.Set("delimiter", "/")
+	}
+
+	if max > 0 {
+		query.Set("max-keys", strconv.Itoa(max))
+	}
+
+	for {
+		var resp *http.Response
+		if resp, e = s.do("GET", "", query, nil, nil); e != nil {
+			return
+		}
+
+		var result s3ListResult
+		if resp.StatusCode == http.StatusOK {
+			e = xml.NewDecoder(resp.Body).Decode(&result)
+		} else {
+			e = s3Error("list", prefix, resp)
+		}
+		resp.Body.Close()
+
+		if e != nil {
+			return nil, nil, e
+		}
+
+		for _, object := range result.Contents {
+			// skip the markers some tools create for empty directories
+			if !strings.HasSuffix(object.Key, "/") {
+				objects = append(objects, object)
+			}
+		}
+
+		for _, prefix := range result.CommonPrefixes {
+			prefixes = append(prefixes, strings.TrimSuffix(prefix.Prefix, "/"))
+		}
+
+		if !result.IsTruncated || (max > 0 && len(objects)+len(prefixes) >= max) {
+			return
+		}
+
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+// copyObject copies the object at from to to
+func (s *S3) copyObject(from, to string) (e error) {
+	header := http.Header{"X-Amz-Copy-Source": {s.copySource(from)}}
+
+	var resp *http.Response
+	if resp, e = s.do("PUT", to, nil, header, nil); e != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return s3Error("copy", from, resp)
+	}
+
+	// a copy can fail after the service has replied 200
+	var body []byte
+	if body, e = ioutil.ReadAll(resp.Body); e != nil {
+		return
+	}
+
+	if bytes.Contains(body, []byte("<Error>")) {
+		return s3ErrorBody("copy", from, body)
+	}
+
+	return nil
+}
+
+func (s *S3) copySource(key string) string {
+	return "/" + s.Bucket + "/" + uriEncode(key, false)
+}
+
+func (s *S3) deleteObject(key string) error {
+	resp, e := s.do("DELETE", key, nil, nil, nil)
+	if e != nil {
+		return e
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return s3Error("remove", key, resp)
+	}
+
+	return nil
+}
+
+// do sends a signed request for the object at key, the bucket if key is empty
+func (s *S3) do(method, key string, query url.Values, header http.Header, body []byte) (resp *http.Response, e error) {
+	var req *http.Request
+	if req, e = http.NewRequest(method, strings.TrimSuffix(s.Endpoint, "/")+"/"+s.Bucket+"/"+uriEncode(key, false), bytes.NewReader(body)); e != nil {
+		return
+	}
+
+	req.URL.RawQuery = query.Encode()
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	payloadHash := emptyPayload
+	if len(body) > 0 {
+		sum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+
+	signRequest(req, s.AccessKey, s.SecretKey, s.Region, payloadHash, time.Now())
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return client.Do(req)
+}
+
+// signRequest adds the headers of AWS signature version 4 to req.  Range and
+// all x-amz headers are signed along with the host
+func signRequest(req *http.Request, accessKey, secretKey, region, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format(s3TimeFormat)
+	scope := amzDate[:8] + "/" + region + "/s3/aws4_request"
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "range" || name == "content-type" || name == "content-md5" {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders string
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	query := req.URL.Query()
+	var keys []string
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parameters []string
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			parameters = append(parameters, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	req.URL.RawQuery = strings.Join(parameters, "&")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{amzDate[:8], region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+hex.EncodeToString(hmacSHA256(key, stringToSign)))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// uriEncode escapes every byte of s except unreserved characters, and slashes
+// unless slash is true
+func uriEncode(s string, slash bool) string {
+	var encoded strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !slash) {
+			encoded.WriteByte(c)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", c)
+		}
+	}
+
+	return encoded.String()
+}
+
+// s3Error returns the error of a failed request, os.ErrNotExist if nothing
+// was found at key
+func s3Error(op, key string, resp *http.Response) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return &os.PathError{Op: op, Path: key, Err: os.ErrNotExist}
+	}
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	if e := s3ErrorBody(op, key, body); e != nil {
+		return e
+	}
+
+	return &os.PathError{Op: op, Path: key, Err: errors.New(resp.Status)}
+}
+
+// s3ErrorBody returns the error described by an error response body, nil if
+// body doesn't describe one
+func s3ErrorBody(op, key string, body []byte) error {
+	var response s3ErrorResponse
+	if xml.Unmarshal(body, &response) != nil || response.Code == "" {
+		return nil
+	}
+
+	return &os.PathError{Op: op, Path: key, Err: errors.New(response.Code + " - " + response.Message)}
+}
+
+// s3Metadata returns the metadata of the object header describes.  Objects
+// ucp didn't write are 0644 and modified when they were stored
+func s3Metadata(header http.Header) wire.FileMetadata {
+	md := wire.FileMetadata{
+		Mode:       0644,
+		UID:        -1,
+		GID:        -1,
+		Status:     wire.OK,
+		StatusText: "OK",
+	}
+
+	if modified, e := http.ParseTime(header.Get("Last-Modified")); e == nil {
+		md.ModTime = modified.UnixNano()
+	}
+
+	if mode, e := strconv.ParseUint(header.Get(s3ModeHeader), 8, 32); e == nil {
+		md.Mode = uint32(os.FileMode(mode) & common.PreservedModeBits)
+	}
+
+	if modTime, e := strconv.ParseInt(header.Get(s3ModTimeHeader), 10, 64); e == nil {
+		md.ModTime = modTime
+	}
+	md.AccessTime = md.ModTime
+
+	return md
+}
+
+type s3File struct {
+	s3     *S3
+	key    string
+	size   int64
+	etag   string
+	md     wire.FileMetadata
+	offset int64
+	body   io.ReadCloser
+	// window caches the last ranged read starting at windowOffset
+	window       []byte
+	windowOffset int64
+}
+
+func (f *s3File) Read(p []byte) (read int, e error) {
+	if f.offset >= f.size {
+		return 0, io.EOF
+	}
+
+	if f.body == nil {
+		if f.body, e = f.get(f.offset, f.size-1); e != nil {
+			return
+		}
+	}
+
+	read, e = f.body.Read(p)
+	f.offset += int64(read)
+	if e == io.EOF && f.offset < f.size {
+		e = io.ErrUnexpectedEOF
+	} else if e == io.EOF && read > 0 {
+		// like a local file the end is reported by the next read
+		e = nil
+	}
+
+	return
+}
+
+// ReadAt reads from a window of the file fetched with a ranged GET
+func (f *s3File) ReadAt(p []byte, offset int64) (read int, e error) {
+	if offset >= f.size {
+		return 0, io.EOF
+	}
+
+	end := offset + int64(len(p))
+	if end > f.size {
+		end = f.size
+	}
+
+	if offset < f.windowOffset || end > f.windowOffset+int64(len(f.window)) {
+		windowEnd := offset + s3ReadAhead
+		if windowEnd < end {
+			windowEnd = end
+		}
+
+		if windowEnd > f.size {
+			windowEnd = f.size
+		}
+
+		var body io.ReadCloser
+		if body, e = f.get(offset, windowEnd-1); e != nil {
+			return
+		}
+		defer body.Close()
+
+		window := make([]byte, windowEnd-offset)
+		if _, e = io.ReadFull(body, window); e != nil {
+			return
+		}
+		f.window, f.windowOffset = window, offset
+	}
+
+	read = copy(p, f.window[offset-f.windowOffset:end-f.windowOffset])
+	if read < len(p) {
+		e = io.EOF
+	}
+
+	return
+}
+
+// get returns the bytes first to last of the file.  The read fails if the
+// object changed since the file was opened
+func (f *s3File) get(first, last int64) (io.ReadCloser, error) {
+	header := http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", first, last)}}
+	if f.etag != "" {
+		header.Set("If-Match", f.etag)
+	}
+
+	resp, e := f.s3.do("GET", f.key, nil, header, nil)
+	if e != nil {
+		return nil, e
+	}
+
+	if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, s3Error("read", f.key, resp)
+	}
+
+	return resp.Body, nil
+}
+
+func (f *s3File) Close() error {
+	if f.body != nil {
+		return f.body.Close()
+	}
+
+	return nil
+}
+
+// Metadata returns the mode and modification time the file was written with.
+// Owners and extended attributes are not kept
+func (f *s3File) Metadata(options wire.TransferOption) (*wire.FileMetadata, error) {
+	md := f.md
+	return &md, nil
+}
+
+// s3PendingFile uploads files larger than a part to uploadKey, a temporary
+// object next to key.  Metadata is only known once the file is committed, so
+// the temporary object is then copied to key in an upload created with it
+type s3PendingFile struct {
+	s3        *S3
+	key       string
+	uploadKey string
+	buffer    bytes.Buffer
+	uploadID  string
+	parts     []s3Part
+	// staged is true while the temporary object exists
+	staged bool
+}
+
+type s3Part struct {
+	PartNumber int
+	ETag       string
+	size       int64
+}
+
+// Write buffers p and uploads every part that is full
+func (f *s3PendingFile) Write(p []byte) (written int, e error) {
+	written, _ = f.buffer.Write(p)
+
+	for partSize := f.partSize(); f.buffer.Len() >= partSize; partSize = f.partSize() {
+		if e = f.uploadPart(f.buffer.Next(partSize)); e != nil {
+			return 0, e
+		}
+	}
+
+	return
+}
+
+// partSize returns the size of the next part, which doubles every
+// s3PartsPerSize parts
+func (f *s3PendingFile) partSize() int {
+	size := f.s3.PartSize
+	if size <= 0 {
+		size = DefaultS3PartSize
+	}
+
+	for i := len(f.parts) / s3PartsPerSize; i > 0 && int64(size)*2 <= s3MaxPartSize; i-- {
+		size *= 2
+	}
+
+	return size
+}
+
+// uploadPart uploads data as the next part of the temporary object, starting
+// a multipart upload with the first one
+func (f *s3PendingFile) uploadPart(data []byte) (e error) {
+	if f.uploadID == "" {
+		if e = f.createUpload(f.uploadKey, nil); e != nil {
+			return
+		}
+	}
+
+	number := len(f.parts) + 1
+	var resp *http.Response
+	if resp, e = f.s3.do("PUT", f.uploadKey, f.partQuery(number), nil, data); e != nil {
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return s3Error("write", f.key, resp)
+	}
+
+	f.parts = append(f.parts, s3Part{PartNumber: number, ETag: resp.Header.Get("ETag"), size: int64(len(data))})
+
+	return nil
+}
+
+// copyPart copies the bytes first to last of the temporary object as the next
+// part of key.  The copy fails if the object no longer has etag
+func (f *s3PendingFile) copyPart(first, last int64, etag string) (e error) {
+	header := http.Header{
+		"X-Amz-Copy-Source":       {f.s3.copySource(f.uploadKey)},
+		"X-Amz-Copy-Source-Range": {fmt.Sprintf("bytes=%d-%d", first, last)},
+	}
+	if etag != "" {
+		header.Set("X-Amz-Copy-Source-If-Match", etag)
+	}
+
+	number := len(f.parts) + 1
+	var resp *http.Response
+	if resp, e = f.s3.do("PUT", f.key, f.partQuery(number), header, nil); e != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return s3Error("write", f.key, resp)
+	}
+
+	// a copy can fail after the service has replied 200
+	var body []byte
+	if body, e = ioutil.ReadAll(resp.Body); e != nil {
+		return
+	}
+
+	if e = s3ErrorBody("write", f.key, body); e != nil {
+		return
+	}
+
+	var result struct {
+		ETag string
+	}
+	if e = xml.Unmarshal(body, &result); e != nil {
+		return
+	}
+
+	f.parts = append(f.parts, s3Part{PartNumber: number, ETag: result.ETag, size: last - first + 1})
+
+	return nil
+}
+
+func (f *s3PendingFile) partQuery(number int) url.Values {
+	return url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {f.uploadID}}
+}
+
+// createUpload starts a multipart upload of the object at key that is stored
+// with the metadata of header
+func (f *s3PendingFile) createUpload(key string, header http.Header) (e error) {
+	var resp *http.Response
+	if resp, e = f.s3.do("POST", key, url.Values{"uploads": {""}}, header, nil); e != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return s3Error("create", f.key, resp)
+	}
+
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if e = xml.NewDecoder(resp.Body).Decode(&result); e != nil {
+		return
+	}
+	f.uploadID = result.UploadID
+
+	return nil
+}
+
+// complete puts the uploaded parts together as the object at key and returns
+// its ETag
+func (f *s3PendingFile) complete(key string) (etag string, e error) {
+	var body []byte
+	if body, e = xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []s3Part `xml:"Part"`
+	}{Parts: f.parts}); e != nil {
+		return
+	}
+
+	var resp *http.Response
+	if resp, e = f.s3.do("POST", key, url.Values{"uploadId": {f.uploadID}}, nil, body); e != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", s3Error("write", f.key, resp)
+	}
+
+	// completing can fail after the service has replied 200
+	if body, e = ioutil.ReadAll(resp.Body); e != nil {
+		return
+	}
+
+	if e = s3ErrorBody("write", f.key, body); e != nil {
+		return
+	}
+
+	var result struct {
+		ETag string
+	}
+	if e = xml.Unmarshal(body, &result); e != nil {
+		return
+	}
+	f.uploadID = ""
+
+	return result.ETag, nil
+}
+
+// Commit stores the file with the mode and modification time of md.  The
+// object at key is only replaced once the file is complete, a commit that
+// fails leaves it as it was
+func (f *s3PendingFile) Commit(md *wire.FileMetadata, options wire.TransferOption) (e error) {
+	header := http.Header{}
+	if md != nil {
+		header.Set(s3ModeHeader, strconv.FormatUint(uint64(os.FileMode(md.Mode)&common.PreservedModeBits), 8))
+		header.Set(s3ModTimeHeader, strconv.FormatInt(md.ModTime, 10))
+	}
+
+	if f.uploadID == "" {
+		var resp *http.Response
+		if resp, e = f.s3.do("PUT", f.key, nil, header, f.buffer.Bytes()); e != nil {
+			return
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return s3Error("write", f.key, resp)
+		}
+
+		return nil
+	}
+
+	if f.buffer.Len() > 0 {
+		if e = f.uploadPart(f.buffer.Bytes()); e != nil {
+			return
+		}
+		f.buffer.Reset()
+	}
+
+	var etag string
+	if etag, e = f.complete(f.uploadKey); e != nil {
+		return
+	}
+	f.staged = true
+
+	// the service copies at most 5GB in one request so parts are copied one
+	// at a time
+	parts := f.parts
+	f.parts = nil
+	if e = f.createUpload(f.key, header); e != nil {
+		return
+	}
+
+	var first int64
+	for _, part := range parts {
+		if e = f.copyPart(first, first+part.size-1, etag); e != nil {
+			return
+		}
+		first += part.size
+	}
+
+	if _, e = f.complete(f.key); e != nil {
+		return
+	}
+
+	// Close tries again if the temporary object can't be removed
+	if f.s3.deleteObject(f.uploadKey) == nil {
+		f.staged = false
+	}
+
+	return nil
+}
+
+// Close aborts an upload that was not completed and removes the temporary
+// object
+func (f *s3PendingFile) Close() (e error) {
+	if f.uploadID != "" {
+		key := f.uploadKey
+		if f.staged {
+			key = f.key
+		}
+
+		var resp *http.Response
+		if resp, e = f.s3.do("DELETE", key, url.Values{"uploadId": {f.uploadID}}, nil, nil); e != nil {
+			return
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+			return s3Error("abort", f.key, resp)
+		}
+		f.uploadID = ""
+	}
+
+	if f.staged {
+		if e = f.s3.deleteObject(f.uploadKey); e != nil {
+			return
+		}
+		f.staged = false
+	}
+
+	return nil
+}
@@ -0,0 +1,48 @@
+// Package storage provides the file systems a ucp server serves files from
+package storage
+
+import (
+	"io"
+	"os"
+
+	"github.com/murphybytes/ucp/wire"
+)
+
+// FS holds the files clients read and write.  Paths are the ones clients
+// send, each FS decides where they are for a user
+type FS interface {
+	// Open opens the file at path for reading
+	Open(userName, path string) (File, error)
+	// Create returns a file that replaces the file at path once it is
+	// committed.  Missing parent directories are created if parents is true
+	Create(userName, path string, parents bool) (PendingFile, error)
+	// Stat describes the file or directory at path
+	Stat(userName, path string) (os.FileInfo, error)
+	// ReadDir describes the entries of the directory at path sorted by name
+	ReadDir(userName, path string) ([]os.FileInfo, error)
+	// Mkdir creates the directory at path.  With parents missing parent
+	// directories are created and an existing directory is not an error
+	Mkdir(userName, path string, parents bool) error
+	// Rename moves the file or directory at from to to
+	Rename(userName, from, to string) error
+	// Remove removes the file or empty directory at path
+	Remove(userName, path string) error
+}
+
+// File is a file opened for reading
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Closer
+	// Metadata returns the attributes of the file options ask for
+	Metadata(options wire.TransferOption) (*wire.FileMetadata, error)
+}
+
+// PendingFile is a file being written.  Closing it before it is committed
+// discards everything written
+type PendingFile interface {
+	io.Writer
+	io.Closer
+	// Commit applies md if it is not nil and puts the file in place
+	Commit(md *wire.FileMetadata, options wire.TransferOption) error
+}
@@ -9,6 +9,7 @@ type AuthenticationRequest struct {
 	UserName                      string
 	RequestedAuthenticationMethod string
 	PublicKey                     rsa.PublicKey
+	ProtocolVersion               int
 }
 
 // AutenticationResponse response to initial request
@@ -18,4 +19,19 @@ type AutenticationResponse struct {
 	Status                      ResponseCode
 	StatusText                  string
 	PublicKey                   rsa.PublicKey
+	// Challenge is signed by the client to prove it holds the private key
+	// of the public key it sent
+	Challenge []byte
+}
+
+// AuthenticationProof answers the challenge of the server
+type AuthenticationProof struct {
+	Signature []byte
+}
+
+// AuthenticationResult tells the client whether it may connect as the user it
+// asked for
+type AuthenticationResult struct {
+	Status     ResponseCode
+	StatusText string
 }
@@ -13,6 +13,24 @@ const (
 	DataBufferSize = 0x10000
 	// ReadBufferSize size of buffer read from network connection
 	ReadBufferSize = 0x2800 + DataBufferSize
+
+	// DeltaBlockSize size of the blocks compared in a delta transfer
+	DeltaBlockSize = 0x2000
+	// MaxSignaturesPerPacket number of block signatures sent in a SignaturePacket
+	MaxSignaturesPerPacket = 0x400
+	// MaxDeltaOpsPerPacket number of delta operations sent in one packet
+	MaxDeltaOpsPerPacket = 0x400
+	// MaxDeltaLiteralSize number of literal bytes sent in one packet
+	MaxDeltaLiteralSize = DataBufferSize / 2
+	// MaxEntriesPerPacket number of file entries sent in a ListingPacket
+	MaxEntriesPerPacket = 0x100
+	// ChallengeSize number of random bytes in an authentication challenge
+	ChallengeSize = 32
+
+	// ProtocolVersion is sent by clients when they authenticate, servers only
+	// accept clients that speak the same version.  Version 2 added the
+	// signed authentication challenge
+	ProtocolVersion = 2
 )
 
 // ResponseCode codes to communicate status of transactions
@@ -29,4 +47,35 @@ const (
 	EOF
 	// More more data to read from client
 	More
+	// Busy the server has too many sessions, try again later
+	Busy
+	// Canceled the peer stopped the transfer, it sends nothing more for it
+	Canceled
+	// NotFound the file or directory does not exist
+	NotFound
+	// PermissionDenied the user may not read or write the file
+	PermissionDenied
+	// DiskFull there is no space left to store the file
+	DiskFull
+	// AuthFailed the server did not accept the user or key
+	AuthFailed
+	// QuotaExceeded the user has used up their quota
+	QuotaExceeded
+	// PathNotAllowed the server does not allow transfers of the path
+	PathNotAllowed
+	// VersionMismatch the client and server speak different protocol versions
+	VersionMismatch
+)
+
+// Compression is the method used to compress file data before it is encrypted
+type Compression int
+
+const (
+	// NoCompression sends file data as is
+	NoCompression Compression = iota
+	// GzipCompression compresses every data packet with gzip
+	GzipCompression
+	// AutoCompression compresses data packets with gzip but sends packets that
+	// don't get smaller as is
+	AutoCompression
 )
@@ -0,0 +1,26 @@
+package wire
+
+// BlockSignature describes one block of the receiver's copy of a file.  Weak
+// is a rolling checksum and Strong an MD5 digest of the block
+type BlockSignature struct {
+	Weak   uint32
+	Strong []byte
+}
+
+// DeltaOp is one step in rebuilding a file from the receiver's copy.  If
+// BlockIndex is not negative the receiver copies that block from its copy,
+// otherwise it appends Literal
+type DeltaOp struct {
+	BlockIndex int
+	Literal    []byte
+}
+
+// SignaturePacket carries a batch of block signatures of the receiver's copy
+// of the file.  Status is More while more packets follow and EOF on the last
+type SignaturePacket struct {
+	NextInitializationVector []byte
+	BlockSize                int
+	Signatures               []BlockSignature
+	Status                   ResponseCode
+	StatusText               string
+}
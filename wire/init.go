@@ -5,12 +5,81 @@ type TransferType int
 const (
 	ClientReading TransferType = iota
 	ClientWriting
+	// ClientListing requests a recursive listing of a directory
+	ClientListing
+	// ClientRemoving removes a file or an empty directory
+	ClientRemoving
+	// ClientPushing asks the server to send a file directly to another server
+	ClientPushing
+	// ClientStating requests a listing holding only the entry of the path
+	ClientStating
+	// ClientMakingDirectory creates a directory, with its missing parents if
+	// CreateDirectories is set
+	ClientMakingDirectory
+	// ClientMoving renames a file or directory to NewPath
+	ClientMoving
 )
 
+var transferNames = map[TransferType]string{
+	ClientReading:         "read",
+	ClientWriting:         "write",
+	ClientListing:         "list",
+	ClientRemoving:        "remove",
+	ClientPushing:         "push",
+	ClientStating:         "stat",
+	ClientMakingDirectory: "mkdir",
+	ClientMoving:          "move",
+}
+
+func (t TransferType) String() string {
+	if name, ok := transferNames[t]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// TransferOption is a set of flags that modify how a transfer is performed
+type TransferOption int
+
+const (
+	// PreserveMetadata copies mode bits and access and modification times
+	PreserveMetadata TransferOption = 1 << iota
+	// PreserveOwner copies the uid and gid of the file
+	PreserveOwner
+	// PreserveXattrs copies extended attributes
+	PreserveXattrs
+	// DeltaTransfer only sends the parts of the file that differ from the
+	// receiver's copy
+	DeltaTransfer
+	// ListChecksums adds a checksum of each file to a listing
+	ListChecksums
+	// CreateDirectories creates missing parent directories of the destination
+	CreateDirectories
+	// ListShallow limits a listing to the entries directly inside the
+	// directory
+	ListShallow
+)
+
+// Has returns true if every option in o is set
+func (t TransferOption) Has(o TransferOption) bool {
+	return t&o == o
+}
+
 type FileTransferRequest struct {
 	UserName string
 	FilePath string
 	Transfer TransferType
+	Options  TransferOption
+	// Compression the client would like to use
+	Compression Compression
+	// Destination of a ClientPushing transfer in the form
+	// user@host:port:/path/to/file
+	Destination string
+	// Offset a ClientReading transfer starts at, clients that lost their
+	// connection continue where they got to
+	Offset int64
+	// NewPath a ClientMoving transfer moves FilePath to
+	NewPath string
 }
 
 type FileTransferResponse struct {
@@ -18,4 +87,10 @@ type FileTransferResponse struct {
 	StatusText           string
 	AESKey               []byte
 	InitializationVector []byte
+	// Compression both sides will use, NoCompression if the server does not
+	// support what the client asked for
+	Compression Compression
+	// FileSize is the size of the file the client reads, -1 if it is not
+	// known
+	FileSize int64
 }
@@ -0,0 +1,23 @@
+package wire
+
+// FileEntry describes a file or directory in a listing.  Name is relative to
+// the listed directory and uses forward slashes.  ModTime is nanoseconds since
+// the unix epoch.  Checksum is an MD5 digest of a file's contents and is only
+// set if the listing was requested with ListChecksums
+type FileEntry struct {
+	Name     string
+	Size     int64
+	ModTime  int64
+	Mode     uint32
+	IsDir    bool
+	Checksum []byte
+}
+
+// ListingPacket carries a batch of entries of a recursive directory listing.
+// Status is More while more packets follow and EOF on the last
+type ListingPacket struct {
+	NextInitializationVector []byte
+	Entries                  []FileEntry
+	Status                   ResponseCode
+	StatusText               string
+}
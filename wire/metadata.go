@@ -0,0 +1,16 @@
+package wire
+
+// FileMetadata carries the attributes of the source file.  It is sent after
+// the file data when the transfer was requested with PreserveMetadata and the
+// receiver applies it to the destination once all the data is written.  ModTime and
+// AccessTime are nanoseconds since the unix epoch
+type FileMetadata struct {
+	Mode       uint32
+	ModTime    int64
+	AccessTime int64
+	UID        int
+	GID        int
+	Xattrs     map[string][]byte
+	Status     ResponseCode
+	StatusText string
+}
@@ -10,9 +10,13 @@ type ReadPacket struct {
 }
 
 // ClientRead contains bytes read from client and sent to server.  If status is
-// not EOF Buffer field contains bytes read from client file
+// not EOF Buffer field contains bytes read from client file.  In a delta
+// transfer Delta contains the operations to apply instead.  Compressed is set
+// if Buffer was compressed with the method negotiated for the transfer
 type ClientRead struct {
 	Buffer     []byte
+	Compressed bool
+	Delta      []DeltaOp
 	Status     ResponseCode
 	StatusText string
 }
@@ -25,16 +29,39 @@ type ClientReadResponse struct {
 	StatusText               string
 }
 
+// KeyConfirmation starts a transfer that changes files without sending file
+// data.  InitializationVector echoes the one sent with the AES key, proving
+// the client could decrypt it
+type KeyConfirmation struct {
+	InitializationVector []byte
+	Status               ResponseCode
+	StatusText           string
+}
+
 // ClientDataRequest sent from client to request a data packet
 type ClientDataRequest struct {
 	Status     ResponseCode
 	StatusText string
 }
 
-// ClientDataResponse respond to ClientDataRequest with size of data expected
+// ClientDataResponse respond to ClientDataRequest with size of data expected.
+// In a delta transfer Delta contains the operations to apply and no data
+// follows.  Compressed is set if the data that follows was compressed with the
+// method negotiated for the transfer
 type ClientDataResponse struct {
 	NextInitializationVector []byte
 	DataSize                 int
+	Compressed               bool
+	Delta                    []DeltaOp
+	Status                   ResponseCode
+	StatusText               string
+}
+
+// StatusResponse is sent in place of the reply the client expects when a
+// transfer fails before any file data was exchanged.  Every reply has the
+// same fields, so the client decodes it as the reply it was waiting for
+type StatusResponse struct {
+	NextInitializationVector []byte
 	Status                   ResponseCode
 	StatusText               string
 }